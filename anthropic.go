@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Anthropic Messages API request/response structures
+type AnthropicRequest struct {
+	Model         string             `json:"model"`
+	MaxTokens     *int               `json:"max_tokens,omitempty"`
+	System        AnthropicContent   `json:"system,omitempty"`
+	Messages      []AnthropicMessage `json:"messages"`
+	Stream        bool               `json:"stream,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	TopK          *int               `json:"top_k,omitempty"`
+	Tools         []AnthropicTool    `json:"tools,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string           `json:"role"`
+	Content AnthropicContent `json:"content"`
+}
+
+// AnthropicContent is a list of content blocks. Requests may also send a
+// plain string, which is read as a single text block.
+type AnthropicContent []AnthropicBlock
+
+type AnthropicBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Source    *AnthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   AnthropicContent      `json:"content,omitempty"`
+	IsError   bool                  `json:"is_error,omitempty"`
+}
+
+type AnthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+type AnthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"`
+}
+
+type AnthropicResponse struct {
+	ID           string           `json:"id"`
+	Type         string           `json:"type"`
+	Role         string           `json:"role"`
+	Model        string           `json:"model"`
+	Content      AnthropicContent `json:"content"`
+	StopReason   *string          `json:"stop_reason"`
+	StopSequence *string          `json:"stop_sequence"`
+	Usage        AnthropicUsage   `json:"usage"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (ac *AnthropicContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*ac = AnthropicContent{{Type: "text", Text: text}}
+		return nil
+	}
+	var blocks []AnthropicBlock
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return errors.New("content must be a string or an array of content blocks")
+	}
+	*ac = blocks
+	return nil
+}
+
+// text joins the text blocks, rejecting blocks that cannot be read as text
+func (ac AnthropicContent) text() (string, error) {
+	texts := make([]string, 0, len(ac))
+	for _, block := range ac {
+		if block.Type != "text" {
+			return "", fmt.Errorf("unsupported content block type %q here", block.Type)
+		}
+		texts = append(texts, block.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// toChatRequest translates an Anthropic request onto the Ollama chat
+// pipeline. tool_use blocks become tool calls on the assistant's turn, and
+// tool_result blocks become tool messages ahead of the rest of their turn.
+func (req AnthropicRequest) toChatRequest() (ChatRequest, error) {
+	var messages []Message
+	toolNames := map[string]string{}
+	system, err := req.System.text()
+	if err != nil {
+		return ChatRequest{}, fmt.Errorf("system: %v", err)
+	}
+	if system != "" {
+		messages = append(messages, Message{Role: "system", Content: system})
+	}
+
+	for i, msg := range req.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return ChatRequest{}, fmt.Errorf("messages.%d: unexpected role %q", i, msg.Role)
+		}
+		var texts, images []string
+		var calls []ToolCall
+		for _, block := range msg.Content {
+			switch {
+			case block.Type == "text":
+				texts = append(texts, block.Text)
+			case block.Type == "image" && msg.Role == "user":
+				image, err := anthropicImage(block.Source)
+				if err != nil {
+					return ChatRequest{}, fmt.Errorf("messages.%d: %v", i, err)
+				}
+				images = append(images, image)
+			case block.Type == "tool_use" && msg.Role == "assistant":
+				arguments, err := toolArguments(block.Input)
+				if err != nil {
+					return ChatRequest{}, fmt.Errorf("messages.%d: tool_use: %v", i, err)
+				}
+				toolNames[block.ID] = block.Name
+				calls = append(calls, ToolCall{Function: FunctionCall{Name: block.Name, Arguments: arguments}})
+			case block.Type == "tool_result" && msg.Role == "user":
+				result, err := block.Content.text()
+				if err != nil {
+					return ChatRequest{}, fmt.Errorf("messages.%d: tool_result: %v", i, err)
+				}
+				if block.IsError {
+					result = "Error: " + result
+				}
+				messages = append(messages, Message{Role: "tool", Content: result, ToolName: toolNames[block.ToolUseID]})
+			default:
+				return ChatRequest{}, fmt.Errorf("messages.%d: unsupported %s content block type %q", i, msg.Role, block.Type)
+			}
+		}
+		if len(texts) > 0 || len(images) > 0 || len(calls) > 0 {
+			messages = append(messages, Message{Role: msg.Role, Content: strings.Join(texts, "\n"), Images: images, ToolCalls: calls})
+		}
+	}
+
+	tools := make([]Tool, 0, len(req.Tools))
+	for _, tool := range req.Tools {
+		tools = append(tools, Tool{
+			Type:     "function",
+			Function: Function{Name: tool.Name, Description: tool.Description, Parameters: tool.InputSchema},
+		})
+	}
+
+	sampling := OpenAISampling{Temperature: req.Temperature, TopP: req.TopP, MaxTokens: req.MaxTokens, Stop: req.StopSequences}
+	options := sampling.options()
+	if req.TopK != nil {
+		if options == nil {
+			options = &Options{}
+		}
+		options.TopK = req.TopK
+	}
+	return ChatRequest{Model: req.Model, Messages: messages, Options: options, Tools: tools}, nil
+}
+
+// anthropicImage returns the base64 payload of an image block
+func anthropicImage(source *AnthropicImageSource) (string, error) {
+	if source == nil || source.Type != "base64" {
+		return "", errors.New("image source must be base64; remote images are not supported")
+	}
+	if _, err := base64.StdEncoding.DecodeString(source.Data); err != nil {
+		return "", fmt.Errorf("invalid base64 image data: %v", err)
+	}
+	return source.Data, nil
+}
+
+// Handle /v1/messages endpoint
+func handleAnthropicMessages(c *gin.Context) {
+	var req AnthropicRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	chatReq, err := req.toChatRequest()
+	if err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	warnIgnoredOptions(c, chatReq.Options)
+	if _, err := registry.Resolve(req.Model); err != nil {
+		respondAnthropicError(c, err)
+		return
+	}
+	if userMessage, images := lastUserMessage(chatReq.Messages); userMessage == "" && len(images) == 0 {
+		anthropicError(c, http.StatusBadRequest, "No user message found")
+		return
+	}
+	defer useModel(req.Model, config.KeepAlive)()
+
+	qreq := chatRequest(chatReq)
+	ctx, stats := newRequestStats(c.Request.Context(), qreq.Prompt)
+	message := AnthropicResponse{
+		ID:    newCompletionID("msg_"),
+		Type:  "message",
+		Role:  "assistant",
+		Model: openAIModel(req.Model),
+	}
+
+	if req.Stream && len(chatReq.Tools) == 0 {
+		streamAnthropic(c, ctx, qreq, stats, message)
+		return
+	}
+
+	response, err := generate(ctx, qreq)
+	if err != nil {
+		respondAnthropicError(c, err)
+		return
+	}
+	metrics := stats.finish(response, doneStop)
+	reply := assistantMessage(response, chatReq.Tools)
+	message.Content = anthropicContent(reply)
+	message.StopReason, message.StopSequence = stopReason(metrics)
+	if len(reply.ToolCalls) > 0 {
+		toolUse := "tool_use"
+		message.StopReason, message.StopSequence = &toolUse, nil
+	}
+	message.Usage = AnthropicUsage{InputTokens: metrics.PromptEvalCount, OutputTokens: metrics.EvalCount}
+	if req.Stream {
+		// A tool call is only recognisable in the complete output, so the
+		// whole message is replayed as events once q has finished
+		replayAnthropic(c, message)
+		return
+	}
+	c.JSON(http.StatusOK, message)
+}
+
+// anthropicContent turns a chat reply into content blocks, with a tool_use
+// block for each tool call
+func anthropicContent(reply Message) AnthropicContent {
+	content := AnthropicContent{}
+	if reply.Content != "" || len(reply.ToolCalls) == 0 {
+		content = append(content, AnthropicBlock{Type: "text", Text: reply.Content})
+	}
+	for _, call := range reply.ToolCalls {
+		input, _ := json.Marshal(call.Function.Arguments)
+		content = append(content, AnthropicBlock{
+			Type:  "tool_use",
+			ID:    newCompletionID("toolu_"),
+			Name:  call.Function.Name,
+			Input: input,
+		})
+	}
+	return content
+}
+
+// replayAnthropic sends a finished message as the events of a stream
+func replayAnthropic(c *gin.Context, message AnthropicResponse) {
+	start := message
+	start.Content = AnthropicContent{}
+	start.StopReason = nil
+	start.Usage.OutputTokens = 0
+	writeSSE(c, "message_start", gin.H{"type": "message_start", "message": start})
+	for i, block := range message.Content {
+		var empty, delta gin.H
+		if block.Type == "tool_use" {
+			empty = gin.H{"type": "tool_use", "id": block.ID, "name": block.Name, "input": gin.H{}}
+			delta = gin.H{"type": "input_json_delta", "partial_json": string(block.Input)}
+		} else {
+			empty = gin.H{"type": "text", "text": ""}
+			delta = gin.H{"type": "text_delta", "text": block.Text}
+		}
+		writeSSE(c, "content_block_start", gin.H{"type": "content_block_start", "index": i, "content_block": empty})
+		writeSSE(c, "content_block_delta", gin.H{"type": "content_block_delta", "index": i, "delta": delta})
+		writeSSE(c, "content_block_stop", gin.H{"type": "content_block_stop", "index": i})
+	}
+	writeSSE(c, "message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": message.StopReason, "stop_sequence": message.StopSequence},
+		"usage": gin.H{"output_tokens": message.Usage.OutputTokens},
+	})
+	writeSSE(c, "message_stop", gin.H{"type": "message_stop"})
+}
+
+// streamAnthropic sends the message as Anthropic's server-sent events: the
+// message, a single text content block, its deltas, and the stop reason
+func streamAnthropic(c *gin.Context, ctx context.Context, qreq QRequest, stats *requestStats, message AnthropicResponse) {
+	started := false
+	begin := func() error {
+		started = true
+		start := message
+		start.Content = AnthropicContent{}
+		start.Usage = AnthropicUsage{InputTokens: countTokens(qreq.Prompt)}
+		if err := writeSSE(c, "message_start", gin.H{"type": "message_start", "message": start}); err != nil {
+			return err
+		}
+		return writeSSE(c, "content_block_start", gin.H{
+			"type":          "content_block_start",
+			"index":         0,
+			"content_block": gin.H{"type": "text", "text": ""},
+		})
+	}
+
+	var response strings.Builder
+	err := stream(ctx, qreq, func(text string) error {
+		if !started {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		response.WriteString(text)
+		return writeSSE(c, "content_block_delta", gin.H{
+			"type":  "content_block_delta",
+			"index": 0,
+			"delta": gin.H{"type": "text_delta", "text": text},
+		})
+	})
+	if err != nil && !started {
+		respondAnthropicError(c, err)
+		return
+	}
+
+	reason := doneReason(ctx, err)
+	switch reason {
+	case doneCancelled:
+		return
+	case doneError:
+		status, _ := errorStatus(err)
+		writeSSE(c, "error", anthropicErrorBody(status, err.Error()))
+		return
+	}
+
+	if !started {
+		begin()
+	}
+	metrics := stats.finish(response.String(), reason)
+	stop, stopSequence := stopReason(metrics)
+	writeSSE(c, "content_block_stop", gin.H{"type": "content_block_stop", "index": 0})
+	writeSSE(c, "message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": stop, "stop_sequence": stopSequence},
+		"usage": gin.H{"output_tokens": metrics.EvalCount},
+	})
+	writeSSE(c, "message_stop", gin.H{"type": "message_stop"})
+}
+
+// stopReason maps the end of a response onto Anthropic's stop_reason and
+// stop_sequence
+func stopReason(metrics responseMetrics) (reason, sequence *string) {
+	stop := "end_turn"
+	switch {
+	case metrics.DoneReason == doneLength:
+		stop = "max_tokens"
+	case metrics.StopSequence != "":
+		stop = "stop_sequence"
+		sequence = &metrics.StopSequence
+	}
+	return &stop, sequence
+}
+
+// respondAnthropicError writes a backend failure in Anthropic's error format
+func respondAnthropicError(c *gin.Context, err error) {
+	status, _ := errorStatus(err)
+	setRetryAfter(c, status)
+	anthropicError(c, status, err.Error())
+}
+
+func anthropicError(c *gin.Context, status int, message string) {
+	c.JSON(status, anthropicErrorBody(status, message))
+}
+
+func anthropicErrorBody(status int, message string) gin.H {
+	errType := "api_error"
+	switch status {
+	case http.StatusBadRequest:
+		errType = "invalid_request_error"
+	case http.StatusUnauthorized:
+		errType = "authentication_error"
+	case http.StatusNotFound:
+		errType = "not_found_error"
+	case http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		errType = "overloaded_error"
+	}
+	return gin.H{"type": "error", "error": gin.H{"type": errType, "message": message}}
+}
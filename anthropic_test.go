@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sseEvent is one decoded server-sent event
+type sseEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// readSSEEvents decodes every named server-sent event in body
+func readSSEEvents(t *testing.T, body string) []sseEvent {
+	var events []sseEvent
+	var name string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if event, ok := strings.CutPrefix(line, "event: "); ok {
+			name = event
+		} else if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var decoded map[string]interface{}
+			require.NoError(t, json.Unmarshal([]byte(data), &decoded), data)
+			events = append(events, sseEvent{name: name, data: decoded})
+		}
+	}
+	return events
+}
+
+func TestAnthropicMessages(t *testing.T) {
+	r := useRegistry(t, newModelRegistry(""))
+	require.NoError(t, r.Put(VirtualModel{Name: "claude-sonnet", From: "amazon-q"}))
+	fake := &fakeBackend{response: "Hello there"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/v1/messages", map[string]interface{}{
+		"model":          "claude-sonnet",
+		"max_tokens":     100,
+		"system":         []map[string]string{{"type": "text", "text": "Be brief"}},
+		"stop_sequences": []string{"END"},
+		"top_k":          5,
+		"messages": []map[string]interface{}{
+			{"role": "user", "content": []map[string]interface{}{
+				{"type": "text", "text": "Describe this"},
+				{"type": "image", "source": map[string]string{"type": "base64", "media_type": "image/png", "data": "aW1n"}},
+			}},
+		},
+	})
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var message AnthropicResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	assert.True(t, strings.HasPrefix(message.ID, "msg_"))
+	assert.Equal(t, "message", message.Type)
+	assert.Equal(t, "assistant", message.Role)
+	assert.Equal(t, "claude-sonnet", message.Model)
+	assert.Equal(t, AnthropicContent{{Type: "text", Text: "Hello there"}}, message.Content)
+	assert.Equal(t, "end_turn", *message.StopReason)
+	assert.Nil(t, message.StopSequence)
+	assert.Equal(t, 2, message.Usage.OutputTokens)
+
+	require.Len(t, fake.requests, 1)
+	assert.Contains(t, fake.requests[0].Prompt, "Be brief")
+	assert.Contains(t, fake.requests[0].Prompt, "Describe this")
+	assert.Equal(t, []string{"aW1n"}, fake.requests[0].Images)
+	assert.Equal(t, 100, *fake.requests[0].Options.NumPredict)
+	assert.Equal(t, []string{"END"}, fake.requests[0].Options.Stop)
+	assert.Equal(t, 5, *fake.requests[0].Options.TopK)
+}
+
+func TestAnthropicToolBlocks(t *testing.T) {
+	req := AnthropicRequest{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"system": "Use tools",
+		"tools": [{"name": "get_weather", "description": "Current weather", "input_schema": {"type": "object"}}],
+		"messages": [
+			{"role": "user", "content": "Weather in Paris?"},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Let me check."},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "18C and sunny"},
+				{"type": "tool_result", "tool_use_id": "toolu_2", "content": [{"type": "text", "text": "timeout"}], "is_error": true},
+				{"type": "text", "text": "Thanks"}
+			]}
+		]
+	}`), &req))
+
+	chatReq, err := req.toChatRequest()
+	require.NoError(t, err)
+	assert.Equal(t, []Message{
+		{Role: "system", Content: "Use tools"},
+		{Role: "user", Content: "Weather in Paris?"},
+		{Role: "assistant", Content: "Let me check.", ToolCalls: []ToolCall{
+			{Function: FunctionCall{Name: "get_weather", Arguments: map[string]interface{}{"city": "Paris"}}},
+		}},
+		{Role: "tool", Content: "18C and sunny", ToolName: "get_weather"},
+		{Role: "tool", Content: "Error: timeout"},
+		{Role: "user", Content: "Thanks"},
+	}, chatReq.Messages)
+	require.Len(t, chatReq.Tools, 1)
+	assert.Equal(t, "get_weather", chatReq.Tools[0].Function.Name)
+	assert.Equal(t, map[string]interface{}{"type": "object"}, chatReq.Tools[0].Function.Parameters)
+}
+
+func TestAnthropicRejectsInvalidRequests(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "unused"})
+
+	cases := map[string]map[string]interface{}{
+		"remote image": {"role": "user", "content": []map[string]interface{}{
+			{"type": "image", "source": map[string]string{"type": "url", "url": "https://example.com/cat.png"}},
+		}},
+		"tool_use from user": {"role": "user", "content": []map[string]interface{}{
+			{"type": "tool_use", "id": "toolu_1", "name": "x", "input": map[string]string{}},
+		}},
+		"unknown role": {"role": "system", "content": "hi"},
+	}
+
+	for name, msg := range cases {
+		t.Run(name, func(t *testing.T) {
+			w := postJSON(t, "/v1/messages", map[string]interface{}{"max_tokens": 10, "messages": []interface{}{msg}})
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), `"type":"invalid_request_error"`)
+		})
+	}
+}
+
+func TestAnthropicBackendError(t *testing.T) {
+	useBackend(t, &fakeBackend{err: &QError{Kind: QErrNotLoggedIn, Message: "Amazon Q is not logged in"}})
+
+	w := postJSON(t, "/v1/messages", map[string]interface{}{
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+	})
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"type":"error","error":{"type":"authentication_error","message":"Amazon Q is not logged in"}}`, w.Body.String())
+}
+
+func TestAnthropicStreaming(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"Hello", " there"}})
+
+	w := postJSON(t, "/v1/messages", map[string]interface{}{
+		"max_tokens": 100,
+		"stream":     true,
+		"messages":   []map[string]string{{"role": "user", "content": "hi"}},
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	events := readSSEEvents(t, w.Body.String())
+	names := make([]string, 0, len(events))
+	for _, event := range events {
+		names = append(names, event.name)
+		assert.Equal(t, event.name, event.data["type"], "the data type matches the event name")
+	}
+	assert.Equal(t, []string{
+		"message_start", "content_block_start", "content_block_delta", "content_block_delta",
+		"content_block_stop", "message_delta", "message_stop",
+	}, names)
+
+	start := events[0].data["message"].(map[string]interface{})
+	assert.True(t, strings.HasPrefix(start["id"].(string), "msg_"))
+	assert.Empty(t, start["content"])
+	assert.Equal(t, "Hello", events[2].data["delta"].(map[string]interface{})["text"])
+	assert.Equal(t, " there", events[3].data["delta"].(map[string]interface{})["text"])
+	assert.Equal(t, "end_turn", events[5].data["delta"].(map[string]interface{})["stop_reason"])
+	assert.Equal(t, float64(2), events[5].data["usage"].(map[string]interface{})["output_tokens"])
+}
+
+func TestAnthropicStopSequence(t *testing.T) {
+	body := map[string]interface{}{
+		"max_tokens":     100,
+		"stop_sequences": []string{"\n\nHuman:", "END"},
+		"messages":       []map[string]string{{"role": "user", "content": "hi"}},
+	}
+
+	useBackend(t, &fakeBackend{chunks: []string{"The answer", " is 42.EN", "D trailing"}})
+	w := postJSON(t, "/v1/messages", body)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var message AnthropicResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	assert.Equal(t, AnthropicContent{{Type: "text", Text: "The answer is 42."}}, message.Content)
+	assert.Equal(t, "stop_sequence", *message.StopReason)
+	require.NotNil(t, message.StopSequence)
+	assert.Equal(t, "END", *message.StopSequence)
+
+	body["stream"] = true
+	useBackend(t, &fakeBackend{chunks: []string{"The answer", " is 42.EN", "D trailing"}})
+	events := readSSEEvents(t, postJSON(t, "/v1/messages", body).Body.String())
+	delta := events[len(events)-2].data["delta"].(map[string]interface{})
+	assert.Equal(t, "stop_sequence", delta["stop_reason"])
+	assert.Equal(t, "END", delta["stop_sequence"])
+}
+
+func TestAnthropicStreamingFailureAfterOutput(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"partial"}, err: errors.New("boom")})
+
+	w := postJSON(t, "/v1/messages", map[string]interface{}{
+		"stream":   true,
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+	})
+
+	events := readSSEEvents(t, w.Body.String())
+	require.Len(t, events, 4)
+	last := events[3]
+	assert.Equal(t, "error", last.name)
+	assert.Equal(t, "api_error", last.data["error"].(map[string]interface{})["type"])
+	assert.Contains(t, last.data["error"].(map[string]interface{})["message"], "boom")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Backend answers prompts on behalf of the HTTP handlers. The q CLI is the
+// production implementation; tests swap in a scripted fake. There is no
+// separate Chat method: chatRequest flattens a conversation into one prompt,
+// so chats take the same Generate and Stream path as everything else.
+type Backend interface {
+	// Generate runs a single prompt and returns the complete response
+	Generate(ctx context.Context, req QRequest) (string, error)
+	// Stream runs a prompt and calls onChunk for every piece of output
+	Stream(ctx context.Context, req QRequest, onChunk func(chunk string) error) error
+}
+
+// QRequest describes a single prompt sent to Amazon Q. Handlers build it the
+// same way whether or not the response is streamed.
+type QRequest struct {
+	Prompt  string
+	Images  []string
+	Options *Options
+	// Flags are extra q chat arguments set by the requested model
+	Flags []string
+	// Files are local files passed to q with --file, which keeps their
+	// contents out of the argument list
+	Files []string
+}
+
+// backend is the Backend used by all handlers
+var backend Backend = &QCLIBackend{}
+
+// qBinary is the Amazon Q CLI executable
+var qBinary = "q"
+
+// QCLIBackend runs every request through the Amazon Q CLI
+type QCLIBackend struct{}
+
+func (b *QCLIBackend) Generate(ctx context.Context, req QRequest) (string, error) {
+	var raw bytes.Buffer
+	if err := runQ(ctx, req, &raw); err != nil {
+		return "", err
+	}
+	return cleanQOutput(raw.String()), nil
+}
+
+func (b *QCLIBackend) Stream(ctx context.Context, req QRequest, onChunk func(chunk string) error) error {
+	// A failed onChunk cancels ctx, which stops q straight away
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	streamer := newChunkStreamer(func(chunk string) error {
+		err := onChunk(chunk)
+		if err != nil {
+			stop()
+		}
+		return err
+	})
+
+	err := runQ(ctx, req, streamer)
+	// Deliver what q printed before reporting how it ended
+	if streamErr := streamer.Close(); streamErr != nil {
+		return streamErr
+	}
+	return err
+}
+
+// runQ is the single path every q invocation takes: it waits for an
+// admission slot, then runs the request on a pooled worker or a one-shot
+// process and copies raw output to out. Failures are returned as QErrors.
+func runQ(ctx context.Context, req QRequest, out io.Writer) error {
+	ctx, cancel := qContext(ctx)
+	defer cancel()
+
+	release, err := admission.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	out = &outputMarker{ctx: ctx, out: out}
+
+	// Warm workers cannot take attachments or flags, so only plain prompts
+	// use the pool
+	if pool := activePool(); pool != nil && len(req.Images) == 0 && len(req.Files) == 0 && len(req.Flags) == 0 {
+		markLoaded(ctx)
+		ok, err := pool.Run(ctx, req.Prompt, func(data []byte) error {
+			_, err := out.Write(data)
+			return err
+		})
+		if ok {
+			if err != nil {
+				return classifyQError(ctx, err, "")
+			}
+			return nil
+		}
+	}
+
+	args := append([]string{"chat"}, req.Flags...)
+	args = append(args, "--message", req.Prompt)
+	files, cleanup := writeAttachments(req.Images)
+	defer cleanup()
+	for _, file := range append(files, req.Files...) {
+		args = append(args, "--file", file)
+	}
+
+	// Keep stderr out of the response; it only matters when q fails
+	var stderr bytes.Buffer
+	cmd := newQCommand(ctx, args...)
+	cmd.Stdout = out
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return classifyQError(ctx, err, "")
+	}
+	markLoaded(ctx)
+	if err := cmd.Wait(); err != nil {
+		return classifyQError(ctx, err, stderr.String())
+	}
+	return nil
+}
+
+// writeAttachments saves base64 images to temporary files for q's --file
+// flag. Images that cannot be decoded or written are skipped.
+func writeAttachments(images []string) (files []string, cleanup func()) {
+	for i, imageData := range images {
+		data, err := base64.StdEncoding.DecodeString(imageData)
+		if err != nil {
+			continue
+		}
+		tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("q_image_%d_%d.png", time.Now().UnixNano(), i))
+		if err := os.WriteFile(tempFile, data, 0644); err != nil {
+			continue
+		}
+		files = append(files, tempFile)
+	}
+	return files, func() {
+		for _, file := range files {
+			os.Remove(file)
+		}
+	}
+}
+
+// qContext applies the configured request timeout to ctx
+func qContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if config.RequestTimeout > 0 {
+		return context.WithTimeout(ctx, config.RequestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// newQCommand builds a q invocation bound to ctx. Cancelling ctx kills q
+// together with every process it started.
+func newQCommand(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, qBinary, args...)
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		log.Printf("Killing q process %d: %v", cmd.Process.Pid, ctx.Err())
+		return killProcessGroup(cmd)
+	}
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// lastUserMessage returns the content and images of the most recent user message
+func lastUserMessage(messages []Message) (string, []string) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content, messages[i].Images
+		}
+	}
+	return "", nil
+}
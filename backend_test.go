@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBackend is a scripted in-memory Backend
+type fakeBackend struct {
+	response string
+	chunks   []string
+	err      error
+	// delay is spent both loading and before the first output
+	delay time.Duration
+
+	requests []QRequest
+}
+
+func (f *fakeBackend) Generate(ctx context.Context, req QRequest) (string, error) {
+	f.requests = append(f.requests, req)
+	time.Sleep(f.delay)
+	markLoaded(ctx)
+	time.Sleep(f.delay)
+	markOutput(ctx)
+	return f.response, f.err
+}
+
+func (f *fakeBackend) Stream(ctx context.Context, req QRequest, onChunk func(chunk string) error) error {
+	f.requests = append(f.requests, req)
+	time.Sleep(f.delay)
+	markLoaded(ctx)
+	time.Sleep(f.delay)
+	markOutput(ctx)
+	chunks := f.chunks
+	if chunks == nil && f.response != "" {
+		// Like the q backend, streaming yields the same answer as Generate
+		chunks = []string{f.response}
+	}
+	for _, chunk := range chunks {
+		if err := onChunk(chunk); err != nil {
+			return err
+		}
+	}
+	return f.err
+}
+
+// useBackend swaps the package backend for the duration of a test
+func useBackend(t *testing.T, b Backend) {
+	previous := backend
+	backend = b
+	t.Cleanup(func() { backend = previous })
+}
+
+// boolPtr returns a pointer to b for optional request fields
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// postJSON sends body as JSON to path on a fresh router
+func postJSON(t *testing.T, path string, body interface{}) *httptest.ResponseRecorder {
+	jsonData, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	setupRouter().ServeHTTP(w, req)
+	return w
+}
+
+// getJSON sends a GET request through the test router
+func getJSON(t *testing.T, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	setupRouter().ServeHTTP(w, req)
+	return w
+}
+
+// readNDJSON decodes every line of an NDJSON body into a generic map
+func readNDJSON(t *testing.T, body string) []map[string]interface{} {
+	var frames []map[string]interface{}
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		var frame map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &frame), scanner.Text())
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func TestGenerateWithFakeBackend(t *testing.T) {
+	fake := &fakeBackend{response: "Hello from Q"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/api/generate", GenerateRequest{
+		Model:  "amazon-q",
+		Prompt: "Say hello",
+		Images: []string{"aW1n"},
+		Stream: boolPtr(false),
+	})
+
+	assert.Equal(t, 200, w.Code)
+	var response GenerateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Hello from Q", response.Response)
+	assert.True(t, response.Done)
+	assert.Equal(t, 3, response.EvalCount)
+
+	require.Len(t, fake.requests, 1)
+	assert.Equal(t, "Say hello", fake.requests[0].Prompt)
+	assert.Equal(t, []string{"aW1n"}, fake.requests[0].Images)
+}
+
+func TestGenerateBackendError(t *testing.T) {
+	useBackend(t, &fakeBackend{err: errors.New("boom")})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "hi"})
+
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "boom")
+}
+
+func TestChatWithFakeBackend(t *testing.T) {
+	fake := &fakeBackend{response: "4"}
+	useBackend(t, fake)
+
+	messages := []Message{
+		{Role: "system", Content: "You are terse"},
+		{Role: "user", Content: "2+2?"},
+	}
+	w := postJSON(t, "/api/chat", ChatRequest{Model: "amazon-q", Messages: messages, Stream: boolPtr(false)})
+
+	assert.Equal(t, 200, w.Code)
+	var response ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "assistant", response.Message.Role)
+	assert.Equal(t, "4", response.Message.Content)
+	assert.True(t, response.Done)
+
+	require.Len(t, fake.requests, 1)
+	assert.Equal(t, "System: You are terse\n\nUser: 2+2?", fake.requests[0].Prompt)
+}
+
+func TestStreamingGenerateWithFakeBackend(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"one", "two"}})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "count", Stream: boolPtr(true)})
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 3)
+	assert.Equal(t, "one", frames[0]["response"])
+	assert.Equal(t, "two", frames[1]["response"])
+	assert.Equal(t, true, frames[2]["done"])
+}
+
+func TestStreamingChatWithFakeBackend(t *testing.T) {
+	fake := &fakeBackend{chunks: []string{"hi"}}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/api/chat", ChatRequest{
+		Model:    "amazon-q",
+		Messages: []Message{{Role: "user", Content: "hello"}},
+		Stream:   boolPtr(true),
+	})
+
+	assert.Equal(t, 200, w.Code)
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 2)
+	assert.Equal(t, "hi", frames[0]["message"].(map[string]interface{})["content"])
+	assert.Equal(t, true, frames[1]["done"])
+	assert.Equal(t, "hello", fake.requests[0].Prompt)
+}
+
+func TestStreamingBackendErrorBeforeOutput(t *testing.T) {
+	useBackend(t, &fakeBackend{err: errors.New("q not found")})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "x", Stream: boolPtr(true)})
+
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Body.String(), "q not found")
+}
+
+func TestStreamingRequestsCarryImagesAndOptions(t *testing.T) {
+	fake := &fakeBackend{chunks: []string{"ok"}}
+	useBackend(t, fake)
+	temperature := 0.5
+	options := &Options{Temperature: &temperature}
+
+	postJSON(t, "/api/generate", GenerateRequest{Prompt: "describe", Images: []string{"aW1n"}, Options: options, Stream: boolPtr(true)})
+	postJSON(t, "/api/chat", ChatRequest{
+		Messages: []Message{{Role: "user", Content: "describe", Images: []string{"aW1n"}}},
+		Options:  options,
+		Stream:   boolPtr(true),
+	})
+
+	require.Len(t, fake.requests, 2)
+	for _, req := range fake.requests {
+		assert.Equal(t, []string{"aW1n"}, req.Images)
+		assert.Equal(t, options, req.Options)
+	}
+}
+
+func TestStreamAndGenerateShareAttachments(t *testing.T) {
+	// Echo the contents of every --file argument back as the answer
+	useFakeQ(t, "#!/bin/sh\nwhile [ $# -gt 0 ]; do\n  if [ \"$1\" = --file ]; then cat \"$2\"; echo; shift; fi\n  shift\ndone\n")
+	req := QRequest{Prompt: "describe", Images: []string{base64.StdEncoding.EncodeToString([]byte("image bytes"))}}
+
+	response, err := (&QCLIBackend{}).Generate(t.Context(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "image bytes", response)
+
+	var streamed strings.Builder
+	err = (&QCLIBackend{}).Stream(t.Context(), req, func(chunk string) error {
+		streamed.WriteString(chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "image bytes", streamed.String())
+}
+
+// TestStreamFieldCompatibility checks Ollama's defaults: an omitted "stream"
+// streams NDJSON and only an explicit false returns a single JSON object
+func TestStreamFieldCompatibility(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "buffered", chunks: []string{"streamed"}})
+	chat := []map[string]string{{"role": "user", "content": "hi"}}
+
+	cases := []struct {
+		name   string
+		stream interface{}
+		ndjson bool
+	}{
+		{"omitted", nil, true},
+		{"true", true, true},
+		{"false", false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for path, body := range map[string]map[string]interface{}{
+				"/api/generate": {"model": "amazon-q", "prompt": "hi"},
+				"/api/chat":     {"model": "amazon-q", "messages": chat},
+			} {
+				if tc.stream != nil {
+					body["stream"] = tc.stream
+				}
+				w := postJSON(t, path, body)
+
+				assert.Equal(t, 200, w.Code, path)
+				frames := readNDJSON(t, w.Body.String())
+				if tc.ndjson {
+					assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"), path)
+					require.Len(t, frames, 2, path)
+					assert.Equal(t, false, frames[0]["done"], path)
+				} else {
+					assert.Contains(t, w.Header().Get("Content-Type"), "application/json", path)
+					require.Len(t, frames, 1, path)
+					assert.Contains(t, w.Body.String(), "buffered", path)
+				}
+				assert.Equal(t, true, frames[len(frames)-1]["done"], path)
+			}
+		})
+	}
+}
@@ -10,7 +10,7 @@ import (
 
 func BenchmarkHealthEndpoint(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -23,7 +23,7 @@ func BenchmarkHealthEndpoint(b *testing.B) {
 
 func BenchmarkPingEndpoint(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -36,7 +36,7 @@ func BenchmarkPingEndpoint(b *testing.B) {
 
 func BenchmarkTagsEndpoint(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -49,7 +49,7 @@ func BenchmarkTagsEndpoint(b *testing.B) {
 
 func BenchmarkPsEndpoint(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -62,7 +62,7 @@ func BenchmarkPsEndpoint(b *testing.B) {
 
 func BenchmarkStatusEndpoint(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -77,7 +77,7 @@ func BenchmarkShowEndpoint(b *testing.B) {
 	router := setupRouter()
 	showReq := ShowRequest{Name: "amazon-q"}
 	jsonData, _ := json.Marshal(showReq)
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -94,10 +94,10 @@ func BenchmarkGenerateEndpoint(b *testing.B) {
 	genReq := GenerateRequest{
 		Model:  "amazon-q",
 		Prompt: "Hello world",
-		Stream: false,
+		Stream: boolPtr(false),
 	}
 	jsonData, _ := json.Marshal(genReq)
-	
+
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		w := httptest.NewRecorder()
@@ -114,10 +114,10 @@ func BenchmarkChatEndpoint(b *testing.B) {
 		Messages: []Message{
 			{Role: "user", Content: "Hello"},
 		},
-		Stream: false,
+		Stream: boolPtr(false),
 	}
 	jsonData, _ := json.Marshal(chatReq)
-	
+
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		w := httptest.NewRecorder()
@@ -136,7 +136,7 @@ func BenchmarkJSONMarshaling(b *testing.B) {
 		EvalCount:     25,
 		EvalDuration:  987654321,
 	}
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -153,7 +153,7 @@ func BenchmarkJSONUnmarshaling(b *testing.B) {
 		"images": [],
 		"options": {"temperature": 0.7}
 	}`
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -165,7 +165,7 @@ func BenchmarkJSONUnmarshaling(b *testing.B) {
 
 func BenchmarkCORSMiddleware(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -179,7 +179,7 @@ func BenchmarkCORSMiddleware(b *testing.B) {
 
 func BenchmarkErrorHandling(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -198,10 +198,10 @@ func BenchmarkMemoryAllocation(b *testing.B) {
 		Prompt: "Test prompt",
 	}
 	jsonData, _ := json.Marshal(genReq)
-	
+
 	b.ResetTimer()
 	b.ReportAllocs()
-	
+
 	for i := 0; i < b.N; i++ {
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("POST", "/api/generate", bytes.NewBuffer(jsonData))
@@ -213,7 +213,7 @@ func BenchmarkMemoryAllocation(b *testing.B) {
 // Benchmark concurrent requests
 func BenchmarkConcurrentHealthRequests(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -226,7 +226,7 @@ func BenchmarkConcurrentHealthRequests(b *testing.B) {
 
 func BenchmarkConcurrentTagsRequests(b *testing.B) {
 	router := setupRouter()
-	
+
 	b.ResetTimer()
 	b.RunParallel(func(pb *testing.PB) {
 		for pb.Next() {
@@ -245,7 +245,7 @@ func BenchmarkSmallPayload(b *testing.B) {
 		Prompt: "Hi",
 	}
 	jsonData, _ := json.Marshal(genReq)
-	
+
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		w := httptest.NewRecorder()
@@ -257,19 +257,19 @@ func BenchmarkSmallPayload(b *testing.B) {
 
 func BenchmarkLargePayload(b *testing.B) {
 	router := setupRouter()
-	
+
 	// Create a large prompt
 	largePrompt := ""
 	for i := 0; i < 1000; i++ {
 		largePrompt += "This is a large prompt for testing performance with bigger payloads. "
 	}
-	
+
 	genReq := GenerateRequest{
 		Model:  "amazon-q",
 		Prompt: largePrompt,
 	}
 	jsonData, _ := json.Marshal(genReq)
-	
+
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		w := httptest.NewRecorder()
@@ -281,7 +281,7 @@ func BenchmarkLargePayload(b *testing.B) {
 
 func BenchmarkComplexChatPayload(b *testing.B) {
 	router := setupRouter()
-	
+
 	// Create a complex chat request with multiple messages
 	messages := make([]Message, 10)
 	for i := 0; i < 10; i++ {
@@ -294,14 +294,14 @@ func BenchmarkComplexChatPayload(b *testing.B) {
 			Content: "This is message number " + string(rune(i)) + " in a complex conversation for benchmarking purposes.",
 		}
 	}
-	
+
 	chatReq := ChatRequest{
 		Model:    "amazon-q",
 		Messages: messages,
-		Stream:   false,
+		Stream:   boolPtr(false),
 	}
 	jsonData, _ := json.Marshal(chatReq)
-	
+
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		w := httptest.NewRecorder()
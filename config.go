@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Config holds runtime settings read from the environment
+type Config struct {
+	// RequestTimeout bounds every q invocation; 0 means no limit
+	RequestTimeout time.Duration
+	// NumParallel is how many q invocations may run at once
+	NumParallel int
+	// MaxQueue is how many requests may wait for a free slot before new ones are rejected
+	MaxQueue int
+	// QueueTimeout is how long a request may wait for a free slot
+	QueueTimeout time.Duration
+	// ChatFormat selects how chat history is rendered for q: plain, xml or markdown
+	ChatFormat string
+	// ChatMaxChars caps the rendered chat and generate history; older turns are
+	// dropped first
+	ChatMaxChars int
+	// ContextMaxEntries caps how many /api/generate conversations are kept
+	ContextMaxEntries int
+	// ContextTTL drops conversations that have not been continued for this long
+	ContextTTL time.Duration
+	// PoolSize is the number of persistent q chat workers; 0 disables the pool
+	PoolSize int
+	// PoolMaxRequests recycles a worker after it has served this many requests
+	PoolMaxRequests int
+	// PoolHealthInterval is how often idle workers are checked and the pool topped up
+	PoolHealthInterval time.Duration
+	// PoolResetCommand is sent to a worker between requests to clear its conversation
+	PoolResetCommand string
+	// StreamFlushInterval is how long streamed output may be buffered before it is sent; 0 sends every read
+	StreamFlushInterval time.Duration
+	// StreamFlushSize sends buffered streamed output as soon as it reaches this many bytes
+	StreamFlushSize int
+	// KeepAlive is how long the model stays loaded after a request that does not set keep_alive
+	KeepAlive time.Duration
+	// JSONRetries is how many times q is asked again when JSON mode gets no valid JSON
+	JSONRetries int
+	// ModelsFile is where virtual models are stored; empty keeps them in memory
+	ModelsFile string
+}
+
+// config is the active configuration, loaded once at startup
+var config = loadConfig()
+
+func loadConfig() Config {
+	return Config{
+		RequestTimeout:      envDuration("Q_REQUEST_TIMEOUT", 5*time.Minute),
+		NumParallel:         envInt("OLLAMA_NUM_PARALLEL", 4),
+		MaxQueue:            envInt("OLLAMA_MAX_QUEUE", 512),
+		QueueTimeout:        envDuration("Q_QUEUE_TIMEOUT", 2*time.Minute),
+		ChatFormat:          envString("Q_CHAT_FORMAT", transcriptPlain),
+		ChatMaxChars:        envInt("Q_CHAT_MAX_CHARS", 32000),
+		ContextMaxEntries:   envInt("Q_CONTEXT_MAX_ENTRIES", 1000),
+		ContextTTL:          envDuration("Q_CONTEXT_TTL", 30*time.Minute),
+		PoolSize:            envInt("Q_POOL_SIZE", 0),
+		PoolMaxRequests:     envInt("Q_POOL_MAX_REQUESTS", 50),
+		PoolHealthInterval:  envDuration("Q_POOL_HEALTH_INTERVAL", 30*time.Second),
+		PoolResetCommand:    envString("Q_POOL_RESET_COMMAND", "/clear"),
+		StreamFlushInterval: envDuration("Q_STREAM_FLUSH_INTERVAL", 50*time.Millisecond),
+		StreamFlushSize:     envInt("Q_STREAM_FLUSH_SIZE", 256),
+		KeepAlive:           envKeepAlive("OLLAMA_KEEP_ALIVE", 5*time.Minute),
+		JSONRetries:         envInt("Q_JSON_RETRIES", 2),
+		ModelsFile:          envString("Q_MODELS_FILE", defaultModelsFile()),
+	}
+}
+
+func envString(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+func envInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %d", key, value, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+func envKeepAlive(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	d, err := parseKeepAlive(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// contextHandleMarker tags the context arrays issued by this server so that
+// token arrays from a real Ollama instance are not mistaken for handles
+const contextHandleMarker = 0x51
+
+// A context handle is the marker followed by a random 64-bit id split into
+// two 32-bit halves, so one client cannot guess another's conversation
+
+// conversations backs the context field of /api/generate
+var conversations = newConversationStore(config.ContextMaxEntries, config.ContextTTL)
+
+// conversationTurn is one prompt/response exchange
+type conversationTurn struct {
+	Prompt   string
+	Response string
+}
+
+type conversationEntry struct {
+	id      uint64
+	turns   []conversationTurn
+	expires time.Time
+}
+
+// conversationStore keeps generate histories behind opaque handles, evicting
+// the least recently used entry when full and any entry past its TTL
+type conversationStore struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	lru      *list.List
+	entries  map[uint64]*list.Element
+	now      func() time.Time
+}
+
+func newConversationStore(capacity int, ttl time.Duration) *conversationStore {
+	return &conversationStore{
+		capacity: capacity,
+		ttl:      ttl,
+		lru:      list.New(),
+		entries:  make(map[uint64]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// Get expands a context handle into its turns. Unknown, expired or foreign
+// handles yield no history.
+func (s *conversationStore) Get(handle []int) []conversationTurn {
+	if len(handle) != 3 || handle[0] != contextHandleMarker {
+		return nil
+	}
+	for _, half := range handle[1:] {
+		if half < 0 || half > 0xffffffff {
+			return nil
+		}
+	}
+	id := uint64(handle[1])<<32 | uint64(handle[2])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[id]
+	if !ok {
+		return nil
+	}
+	entry := elem.Value.(*conversationEntry)
+	if s.now().After(entry.expires) {
+		s.remove(elem)
+		return nil
+	}
+	entry.expires = s.now().Add(s.ttl)
+	s.lru.MoveToFront(elem)
+	return entry.turns
+}
+
+// Save stores history followed by turn and returns the handle for it
+func (s *conversationStore) Save(history []conversationTurn, turn conversationTurn) []int {
+	if s.capacity <= 0 {
+		return nil
+	}
+
+	turns := make([]conversationTurn, len(history), len(history)+1)
+	copy(turns, history)
+	turns = append(turns, turn)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictExpired()
+	for s.lru.Len() >= s.capacity {
+		s.remove(s.lru.Back())
+	}
+
+	entry := &conversationEntry{id: s.newID(), turns: turns, expires: s.now().Add(s.ttl)}
+	s.entries[entry.id] = s.lru.PushFront(entry)
+	return []int{contextHandleMarker, int(entry.id >> 32), int(entry.id & 0xffffffff)}
+}
+
+// newID picks a random id that is not in use
+func (s *conversationStore) newID() uint64 {
+	var b [8]byte
+	for {
+		rand.Read(b[:])
+		id := binary.BigEndian.Uint64(b[:])
+		if _, taken := s.entries[id]; !taken {
+			return id
+		}
+	}
+}
+
+// Len reports how many conversations are stored
+func (s *conversationStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lru.Len()
+}
+
+func (s *conversationStore) evictExpired() {
+	now := s.now()
+	for elem := s.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if now.After(elem.Value.(*conversationEntry).expires) {
+			s.remove(elem)
+		}
+		elem = prev
+	}
+}
+
+func (s *conversationStore) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*conversationEntry).id)
+}
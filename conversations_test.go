@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useConversations swaps the conversation store for the duration of a test
+func useConversations(t *testing.T, s *conversationStore) {
+	previous := conversations
+	conversations = s
+	t.Cleanup(func() { conversations = previous })
+}
+
+func TestConversationStoreRoundTrip(t *testing.T) {
+	s := newConversationStore(10, time.Minute)
+
+	first := s.Save(nil, conversationTurn{Prompt: "hi", Response: "hello"})
+	second := s.Save(s.Get(first), conversationTurn{Prompt: "how are you", Response: "fine"})
+
+	assert.Equal(t, []conversationTurn{
+		{Prompt: "hi", Response: "hello"},
+		{Prompt: "how are you", Response: "fine"},
+	}, s.Get(second))
+	assert.Len(t, s.Get(first), 1, "earlier handles stay valid")
+}
+
+func TestConversationStoreIgnoresForeignContext(t *testing.T) {
+	s := newConversationStore(10, time.Minute)
+	s.Save(nil, conversationTurn{Prompt: "hi"})
+
+	assert.Nil(t, s.Get(nil))
+	assert.Nil(t, s.Get([]int{128006, 882, 128007}))
+	assert.Nil(t, s.Get([]int{contextHandleMarker, 999}))
+	assert.Nil(t, s.Get([]int{contextHandleMarker, 0, 1}))
+	assert.Nil(t, s.Get([]int{contextHandleMarker, -1, 1 << 32}))
+}
+
+func TestConversationHandlesAreNotSequential(t *testing.T) {
+	s := newConversationStore(10, time.Minute)
+	first := s.Save(nil, conversationTurn{Prompt: "a"})
+	second := s.Save(nil, conversationTurn{Prompt: "b"})
+	require.Len(t, first, 3)
+	require.Len(t, second, 3)
+
+	assert.NotEqual(t, first[1:], second[1:])
+	// Neighbours of a handle lead nowhere
+	assert.Nil(t, s.Get([]int{contextHandleMarker, first[1], first[2] + 1}))
+	assert.Nil(t, s.Get([]int{contextHandleMarker, first[1], first[2] - 1}))
+}
+
+func TestConversationStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	s := newConversationStore(2, time.Minute)
+	a := s.Save(nil, conversationTurn{Prompt: "a"})
+	b := s.Save(nil, conversationTurn{Prompt: "b"})
+
+	s.Get(a) // a is now more recent than b
+	c := s.Save(nil, conversationTurn{Prompt: "c"})
+
+	assert.NotNil(t, s.Get(a))
+	assert.Nil(t, s.Get(b))
+	assert.NotNil(t, s.Get(c))
+	assert.Equal(t, 2, s.Len())
+}
+
+func TestConversationStoreExpires(t *testing.T) {
+	now := time.Now()
+	s := newConversationStore(10, time.Minute)
+	s.now = func() time.Time { return now }
+
+	handle := s.Save(nil, conversationTurn{Prompt: "a"})
+	now = now.Add(2 * time.Minute)
+
+	assert.Nil(t, s.Get(handle))
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestGenerateContextRoundTrip(t *testing.T) {
+	useConversations(t, newConversationStore(10, time.Minute))
+	fake := &fakeBackend{response: "Paris"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "Capital of France?", Stream: boolPtr(false)})
+	require.Equal(t, 200, w.Code)
+	var first GenerateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	require.NotEmpty(t, first.Context)
+
+	fake.response = "About 2 million"
+	w = postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "Population?", Context: first.Context, Stream: boolPtr(false)})
+	require.Equal(t, 200, w.Code)
+
+	assert.Equal(t, "Capital of France?\n\nParis\n\nPopulation?", fake.requests[1].Prompt)
+}
+
+func TestStreamingGenerateReturnsContext(t *testing.T) {
+	useConversations(t, newConversationStore(10, time.Minute))
+	useBackend(t, &fakeBackend{chunks: []string{"line 1\n", "line 2"}})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "two lines", Stream: boolPtr(true)})
+
+	frames := readNDJSON(t, w.Body.String())
+	final := frames[len(frames)-1]
+	require.NotNil(t, final["context"])
+
+	var handle []int
+	for _, v := range final["context"].([]interface{}) {
+		handle = append(handle, int(v.(float64)))
+	}
+	turns := conversations.Get(handle)
+	require.Len(t, turns, 1)
+	assert.Equal(t, "line 1\nline 2", turns[0].Response)
+}
+
+func TestRawGenerateHasNoContext(t *testing.T) {
+	useConversations(t, newConversationStore(10, time.Minute))
+	useBackend(t, &fakeBackend{response: "ok"})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Prompt: "hi", Raw: true, Stream: boolPtr(false)})
+
+	var response GenerateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Empty(t, response.Context)
+	assert.Equal(t, 0, conversations.Len())
+}
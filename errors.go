@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// QErrorKind classifies why a q invocation failed
+type QErrorKind string
+
+const (
+	QErrBinaryNotFound QErrorKind = "binary_not_found"
+	QErrNotLoggedIn    QErrorKind = "not_logged_in"
+	QErrThrottled      QErrorKind = "throttled"
+	QErrTimeout        QErrorKind = "timeout"
+	QErrKilled         QErrorKind = "killed"
+	QErrUnknown        QErrorKind = "unknown"
+)
+
+var (
+	notLoggedInPattern = regexp.MustCompile(`(?i)not logged in|log ?in required|please (run )?.?q login|token (has )?expired|expired token|invalid_grant|unauthori[sz]ed`)
+	throttledPattern   = regexp.MustCompile(`(?i)throttl|rate exceeded|too many requests|service quota`)
+)
+
+// QError is a classified q failure. Error returns a message that is safe to
+// show to clients; the raw stderr is kept for logs only.
+type QError struct {
+	Kind    QErrorKind
+	Message string
+	Stderr  string
+	Err     error
+}
+
+func (e *QError) Error() string {
+	return e.Message
+}
+
+func (e *QError) Unwrap() error {
+	return e.Err
+}
+
+// Status maps the failure onto the HTTP status returned to clients
+func (e *QError) Status() int {
+	switch e.Kind {
+	case QErrBinaryNotFound:
+		return http.StatusServiceUnavailable
+	case QErrNotLoggedIn:
+		return http.StatusUnauthorized
+	case QErrThrottled:
+		return http.StatusTooManyRequests
+	case QErrTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// classifyQError turns a failed q invocation into a QError using the process
+// error, the request context and whatever q printed on stderr
+func classifyQError(ctx context.Context, err error, stderr string) *QError {
+	stderr = strings.TrimSpace(stripANSI(stderr))
+	qerr := &QError{Kind: QErrUnknown, Stderr: stderr, Err: err}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist):
+		qerr.Kind = QErrBinaryNotFound
+		qerr.Message = "Amazon Q CLI (q) is not installed or not on PATH"
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		qerr.Kind = QErrTimeout
+		qerr.Message = "Amazon Q did not respond in time"
+		qerr.Err = ctx.Err()
+	case errors.Is(ctx.Err(), context.Canceled):
+		qerr.Kind = QErrKilled
+		qerr.Message = "request cancelled"
+		qerr.Err = ctx.Err()
+	case notLoggedInPattern.MatchString(stderr):
+		qerr.Kind = QErrNotLoggedIn
+		qerr.Message = "Amazon Q is not logged in or the session has expired; run `q login`"
+	case throttledPattern.MatchString(stderr):
+		qerr.Kind = QErrThrottled
+		qerr.Message = "Amazon Q is throttling requests; retry later"
+	case errors.As(err, &exitErr) && signalled(exitErr):
+		qerr.Kind = QErrKilled
+		qerr.Message = fmt.Sprintf("q was killed: %v", exitErr)
+	case errors.As(err, &exitErr):
+		qerr.Message = fmt.Sprintf("q exited with status %d", exitErr.ExitCode())
+	default:
+		qerr.Message = fmt.Sprintf("q command failed: %v", err)
+	}
+
+	if stderr != "" {
+		log.Printf("q failed (%s): %s", qerr.Kind, stderr)
+	}
+	return qerr
+}
+
+// signalled reports whether the process was terminated by a signal
+func signalled(exitErr *exec.ExitError) bool {
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled()
+}
+
+// errorStatus maps any backend error onto an HTTP status and machine-readable code
+func errorStatus(err error) (int, string) {
+	var qerr *QError
+	var jsonErr *JSONModeError
+	var notFound *ModelNotFoundError
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound, "model_not_found"
+	case errors.Is(err, errQueueFull):
+		return http.StatusServiceUnavailable, "queue_full"
+	case errors.Is(err, errQueueTimeout):
+		return http.StatusServiceUnavailable, "queue_timeout"
+	case errors.As(err, &qerr):
+		return qerr.Status(), string(qerr.Kind)
+	case errors.As(err, &jsonErr) && len(jsonErr.Violations) > 0:
+		return http.StatusBadGateway, "schema_mismatch"
+	case errors.As(err, &jsonErr):
+		return http.StatusBadGateway, "invalid_json"
+	default:
+		return http.StatusInternalServerError, string(QErrUnknown)
+	}
+}
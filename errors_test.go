@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyQError(t *testing.T) {
+	cases := []struct {
+		name   string
+		script string
+		kind   QErrorKind
+		status int
+	}{
+		{"not logged in", "#!/bin/sh\necho 'error: You are not logged in, please log in with q login' >&2\nexit 1\n", QErrNotLoggedIn, http.StatusUnauthorized},
+		{"token expired", "#!/bin/sh\necho 'Error: token has expired' >&2\nexit 1\n", QErrNotLoggedIn, http.StatusUnauthorized},
+		{"throttled", "#!/bin/sh\necho 'ThrottlingException: Rate exceeded' >&2\nexit 1\n", QErrThrottled, http.StatusTooManyRequests},
+		{"killed", "#!/bin/sh\nkill -9 $$\n", QErrKilled, http.StatusInternalServerError},
+		{"unknown", "#!/bin/sh\necho 'something odd' >&2\nexit 3\n", QErrUnknown, http.StatusInternalServerError},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useFakeQ(t, tc.script)
+
+			_, err := executeQCommand(context.Background(), "hi", nil)
+
+			var qerr *QError
+			require.True(t, errors.As(err, &qerr), "unexpected error: %v", err)
+			assert.Equal(t, tc.kind, qerr.Kind)
+			assert.Equal(t, tc.status, qerr.Status())
+		})
+	}
+}
+
+func TestClassifyQErrorKeepsStderrOutOfMessage(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\necho 'secret-account-id 123456789012' >&2\nexit 1\n")
+
+	_, err := executeQCommand(context.Background(), "hi", nil)
+
+	assert.EqualError(t, err, "q exited with status 1")
+	var qerr *QError
+	require.True(t, errors.As(err, &qerr))
+	assert.Contains(t, qerr.Stderr, "secret-account-id")
+}
+
+func TestClassifyBinaryNotFound(t *testing.T) {
+	previous := qBinary
+	qBinary = "q-binary-that-does-not-exist"
+	defer func() { qBinary = previous }()
+
+	_, err := executeQCommand(context.Background(), "hi", nil)
+
+	var qerr *QError
+	require.True(t, errors.As(err, &qerr))
+	assert.Equal(t, QErrBinaryNotFound, qerr.Kind)
+	assert.Equal(t, http.StatusServiceUnavailable, qerr.Status())
+}
+
+func TestClassifyTimeout(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\nsleep 30\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := executeQCommand(ctx, "hi", nil)
+
+	var qerr *QError
+	require.True(t, errors.As(err, &qerr))
+	assert.Equal(t, QErrTimeout, qerr.Kind)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, http.StatusGatewayTimeout, qerr.Status())
+}
+
+func TestErrorResponseBody(t *testing.T) {
+	useBackend(t, &fakeBackend{err: &QError{Kind: QErrThrottled, Message: "Amazon Q is throttling requests; retry later"}})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "hi"})
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, retryAfterSeconds, w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "Amazon Q is throttling requests; retry later", "code": "throttled"}`, w.Body.String())
+}
+
+func TestStreamFailureAfterOutputSendsErrorFrame(t *testing.T) {
+	cases := []struct {
+		name    string
+		script  string
+		timeout time.Duration
+		code    string
+		message string
+	}{
+		{"exit status", "#!/bin/sh\nprintf 'partial answer\\n'\necho boom >&2\nexit 3\n", 0, "unknown", "q exited with status 3"},
+		{"killed", "#!/bin/sh\nprintf 'partial answer\\n'\nkill -9 $$\n", 0, "killed", "q was killed"},
+		{"timeout", "#!/bin/sh\nprintf 'partial answer\\n'\nsleep 30\n", 300 * time.Millisecond, "timeout", "did not respond in time"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			useFakeQ(t, tc.script)
+			previous := config.RequestTimeout
+			config.RequestTimeout = tc.timeout
+			t.Cleanup(func() { config.RequestTimeout = previous })
+
+			w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "hi", Stream: boolPtr(true)})
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			frames := readNDJSON(t, w.Body.String())
+			require.Len(t, frames, 3)
+			assert.Equal(t, "partial answer", frames[0]["response"])
+			assert.Contains(t, frames[1]["error"], tc.message)
+			assert.Equal(t, tc.code, frames[1]["code"])
+			assert.Equal(t, true, frames[2]["done"])
+			assert.Equal(t, "error", frames[2]["done_reason"])
+			assert.NotContains(t, frames[2], "context", "a failed exchange must not be resumable")
+		})
+	}
+}
+
+func TestStreamingChatFailureAfterOutputSendsErrorFrame(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\nprintf 'half of '\nsleep 0.2\necho 'ThrottlingException: Rate exceeded' >&2\nexit 1\n")
+
+	w := postJSON(t, "/api/chat", ChatRequest{
+		Model:    "amazon-q",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+		Stream:   boolPtr(true),
+	})
+
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 3)
+	assert.Equal(t, "half of ", frames[0]["message"].(map[string]interface{})["content"])
+	assert.Equal(t, "throttled", frames[1]["code"])
+	assert.Equal(t, "error", frames[2]["done_reason"])
+}
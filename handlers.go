@@ -1,14 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"encoding/base64"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -18,32 +17,37 @@ import (
 
 // OLLAMA API request/response structures
 type GenerateRequest struct {
-	Model    string                 `json:"model"`
-	Prompt   string                 `json:"prompt"`
-	Images   []string               `json:"images,omitempty"`
-	Format   string                 `json:"format,omitempty"`
-	Options  map[string]interface{} `json:"options,omitempty"`
-	System   string                 `json:"system,omitempty"`
-	Template string                 `json:"template,omitempty"`
-	Context  []int                  `json:"context,omitempty"`
-	Stream   bool                   `json:"stream,omitempty"`
-	Raw      bool                   `json:"raw,omitempty"`
+	Model     string     `json:"model"`
+	Prompt    string     `json:"prompt"`
+	Suffix    string     `json:"suffix,omitempty"`
+	Images    []string   `json:"images,omitempty"`
+	Format    *Format    `json:"format,omitempty"`
+	Options   *Options   `json:"options,omitempty"`
+	System    string     `json:"system,omitempty"`
+	Template  string     `json:"template,omitempty"`
+	Context   []int      `json:"context,omitempty"`
+	Stream    *bool      `json:"stream,omitempty"`
+	Raw       bool       `json:"raw,omitempty"`
+	KeepAlive *KeepAlive `json:"keep_alive,omitempty"`
 }
 
 type ChatRequest struct {
-	Model    string                 `json:"model"`
-	Messages []Message              `json:"messages"`
-	Format   string                 `json:"format,omitempty"`
-	Options  map[string]interface{} `json:"options,omitempty"`
-	Stream   bool                   `json:"stream,omitempty"`
-	Tools    []Tool                 `json:"tools,omitempty"`
+	Model     string     `json:"model"`
+	Messages  []Message  `json:"messages"`
+	Format    *Format    `json:"format,omitempty"`
+	Options   *Options   `json:"options,omitempty"`
+	Stream    *bool      `json:"stream,omitempty"`
+	Tools     []Tool     `json:"tools,omitempty"`
+	KeepAlive *KeepAlive `json:"keep_alive,omitempty"`
 }
 
 type Message struct {
-	Role     string   `json:"role"`
-	Content  string   `json:"content"`
-	Images   []string `json:"images,omitempty"`
-	ToolCall *ToolCall `json:"tool_calls,omitempty"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	Images    []string   `json:"images,omitempty"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolName names the tool whose result a "tool" message carries
+	ToolName string `json:"tool_name,omitempty"`
 }
 
 type Tool struct {
@@ -62,14 +66,15 @@ type ToolCall struct {
 }
 
 type FunctionCall struct {
-	Name      string `json:"name"`
-	Arguments string `json:"arguments"`
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
 }
 
 type GenerateResponse struct {
 	Model              string    `json:"model"`
 	Response           string    `json:"response"`
 	Done               bool      `json:"done"`
+	DoneReason         string    `json:"done_reason,omitempty"`
 	Context            []int     `json:"context,omitempty"`
 	TotalDuration      int64     `json:"total_duration,omitempty"`
 	LoadDuration       int64     `json:"load_duration,omitempty"`
@@ -84,6 +89,7 @@ type ChatResponse struct {
 	Model              string    `json:"model"`
 	Message            Message   `json:"message"`
 	Done               bool      `json:"done"`
+	DoneReason         string    `json:"done_reason,omitempty"`
 	TotalDuration      int64     `json:"total_duration,omitempty"`
 	LoadDuration       int64     `json:"load_duration,omitempty"`
 	PromptEvalCount    int       `json:"prompt_eval_count,omitempty"`
@@ -98,14 +104,14 @@ type TagsResponse struct {
 }
 
 type ModelInfo struct {
-	Name       string            `json:"name"`
-	Model      string            `json:"model"`
-	ModifiedAt time.Time         `json:"modified_at"`
-	Size       int64             `json:"size"`
-	Digest     string            `json:"digest"`
-	Details    ModelDetails      `json:"details"`
-	ExpiresAt  *time.Time        `json:"expires_at,omitempty"`
-	SizeVram   int64             `json:"size_vram,omitempty"`
+	Name       string       `json:"name"`
+	Model      string       `json:"model"`
+	ModifiedAt time.Time    `json:"modified_at"`
+	Size       int64        `json:"size"`
+	Digest     string       `json:"digest"`
+	Details    ModelDetails `json:"details"`
+	ExpiresAt  *time.Time   `json:"expires_at,omitempty"`
+	SizeVram   int64        `json:"size_vram,omitempty"`
 }
 
 type ModelDetails struct {
@@ -117,11 +123,24 @@ type ModelDetails struct {
 	QuantizationLevel string   `json:"quantization_level"`
 }
 
+// CreateRequest accepts a Modelfile, as older Ollama clients send, or the
+// fields newer clients parse out of one
 type CreateRequest struct {
+	Model     string `json:"model,omitempty"`
 	Name      string `json:"name"`
 	Modelfile string `json:"modelfile,omitempty"`
-	Stream    bool   `json:"stream,omitempty"`
+	Stream    *bool  `json:"stream,omitempty"`
 	Path      string `json:"path,omitempty"`
+
+	From       string                 `json:"from,omitempty"`
+	System     string                 `json:"system,omitempty"`
+	Template   string                 `json:"template,omitempty"`
+	License    modelLicense           `json:"license,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Messages   []Message              `json:"messages,omitempty"`
+	// Files and Adapters carry model weights, which q cannot load
+	Files    map[string]string `json:"files,omitempty"`
+	Adapters map[string]string `json:"adapters,omitempty"`
 }
 
 type PullRequest struct {
@@ -173,43 +192,10 @@ type BlobsRequest struct {
 	Digest string `json:"digest"`
 }
 
-// Execute Amazon Q CLI command with optional file attachments
-func executeQCommand(prompt string, images []string) (string, error) {
-	args := []string{"chat", "--message", prompt}
-	
-	// Handle image attachments by saving them temporarily and using file paths
-	var tempFiles []string
-	defer func() {
-		// Clean up temporary files
-		for _, file := range tempFiles {
-			os.Remove(file)
-		}
-	}()
-
-	for i, imageData := range images {
-		// Decode base64 image data
-		data, err := base64.StdEncoding.DecodeString(imageData)
-		if err != nil {
-			continue // Skip invalid images
-		}
-
-		// Create temporary file
-		tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("q_image_%d_%d.png", time.Now().Unix(), i))
-		if err := os.WriteFile(tempFile, data, 0644); err != nil {
-			continue // Skip if can't write file
-		}
-		
-		tempFiles = append(tempFiles, tempFile)
-		// Add file argument to Q CLI (assuming Q supports file attachments)
-		args = append(args, "--file", tempFile)
-	}
-
-	cmd := exec.Command("q", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("q command failed: %v, output: %s", err, string(output))
-	}
-	return strings.TrimSpace(string(output)), nil
+// Execute Amazon Q CLI command with optional file attachments. The command is
+// killed when ctx is cancelled or the configured timeout expires.
+func executeQCommand(ctx context.Context, prompt string, images []string) (string, error) {
+	return backend.Generate(ctx, QRequest{Prompt: prompt, Images: images})
 }
 
 // Handle /api/generate endpoint
@@ -219,79 +205,205 @@ func handleGenerate(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	warnIgnoredOptions(c, req.Options)
+	if _, err := registry.Resolve(req.Model); err != nil {
+		respondError(c, err)
+		return
+	}
 
-	if req.Stream {
-		handleStreamingGenerate(c, req)
+	// An empty prompt only loads or unloads the model
+	if req.Prompt == "" && len(req.Images) == 0 {
+		if req.Model == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+			return
+		}
+		c.JSON(http.StatusOK, GenerateResponse{
+			Model:      responseModel(req.Model),
+			Done:       true,
+			DoneReason: loadModel(req.Model, req.KeepAlive),
+			CreatedAt:  time.Now(),
+		})
 		return
 	}
+	defer useModel(req.Model, keepAliveFor(req.KeepAlive))()
 
-	startTime := time.Now()
-	response, err := executeQCommand(req.Prompt, req.Images)
+	var history []conversationTurn
+	if !req.Raw {
+		history = conversations.Get(req.Context)
+		if len(history) == 0 {
+			history = messageTurns(resolveModel(req.Model).Messages)
+		}
+	}
+	prompt, err := generatePrompt(req, history)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	duration := time.Since(startTime)
 
-	c.JSON(http.StatusOK, GenerateResponse{
-		Model:         "amazon-q",
-		Response:      response,
-		Done:          true,
-		TotalDuration: duration.Nanoseconds(),
-		EvalCount:     len(strings.Fields(response)),
-		EvalDuration:  duration.Nanoseconds(),
-		CreatedAt:     time.Now(),
-	})
-}
-
-// Handle streaming generate requests
-func handleStreamingGenerate(c *gin.Context, req GenerateRequest) {
-	c.Header("Content-Type", "application/x-ndjson")
-	c.Header("Transfer-Encoding", "chunked")
+	if streamEnabled(req.Stream) {
+		handleStreamingGenerate(c, req, prompt, history)
+		return
+	}
 
-	args := []string{"chat", "--message", req.Prompt}
-	cmd := exec.Command("q", args...)
-	stdout, err := cmd.StdoutPipe()
+	ctx, stats := newRequestStats(c.Request.Context(), prompt)
+	response, err := generateFormatted(ctx, generateRequest(req, prompt), req.Format)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
 
-	if err := cmd.Start(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	final := GenerateResponse{
+		Model:     responseModel(req.Model),
+		Response:  response,
+		Done:      true,
+		Context:   saveGenerateContext(req, history, response),
+		CreatedAt: time.Now(),
 	}
+	stats.finish(response, doneStop).applyGenerate(&final)
+	c.JSON(http.StatusOK, final)
+}
 
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line != "" {
-			response := GenerateResponse{
-				Model:     "amazon-q",
-				Response:  line,
-				Done:      false,
-				CreatedAt: time.Now(),
-			}
-			jsonData, _ := json.Marshal(response)
-			c.Writer.Write(jsonData)
-			c.Writer.Write([]byte("\n"))
-			c.Writer.Flush()
+// Handle streaming generate requests
+func handleStreamingGenerate(c *gin.Context, req GenerateRequest, prompt string, history []conversationTurn) {
+	ctx, stats := newRequestStats(c.Request.Context(), prompt)
+	if req.Format.enabled() {
+		// Formatted output is only checked once complete, so it is sent as
+		// a single chunk
+		response, err := generateFormatted(ctx, generateRequest(req, prompt), req.Format)
+		if err != nil {
+			respondError(c, err)
+			return
 		}
+		writeNDJSON(c, GenerateResponse{
+			Model:     responseModel(req.Model),
+			Response:  response,
+			Done:      false,
+			CreatedAt: time.Now(),
+		})
+		final := GenerateResponse{
+			Model:     responseModel(req.Model),
+			Done:      true,
+			Context:   saveGenerateContext(req, history, response),
+			CreatedAt: time.Now(),
+		}
+		stats.finish(response, doneStop).applyGenerate(&final)
+		writeNDJSON(c, final)
+		return
 	}
 
-	// Send final response
-	finalResponse := GenerateResponse{
-		Model:     "amazon-q",
+	started := false
+	var response []string
+	err := stream(ctx, generateRequest(req, prompt), func(chunk string) error {
+		started = true
+		response = append(response, chunk)
+		return writeNDJSON(c, GenerateResponse{
+			Model:     responseModel(req.Model),
+			Response:  chunk,
+			Done:      false,
+			CreatedAt: time.Now(),
+		})
+	})
+	if err != nil && !started {
+		respondError(c, err)
+		return
+	}
+	reason := doneReason(ctx, err)
+	if reason == doneError {
+		writeStreamError(c, err)
+	}
+
+	// Send final response; a failed exchange is not kept as context
+	text := strings.Join(response, "")
+	final := GenerateResponse{
+		Model:     responseModel(req.Model),
 		Response:  "",
 		Done:      true,
 		CreatedAt: time.Now(),
 	}
-	jsonData, _ := json.Marshal(finalResponse)
-	c.Writer.Write(jsonData)
-	c.Writer.Write([]byte("\n"))
-	c.Writer.Flush()
+	if err == nil {
+		final.Context = saveGenerateContext(req, history, text)
+	}
+	stats.finish(text, reason).applyGenerate(&final)
+	writeNDJSON(c, final)
+}
+
+// loadModel handles a request with nothing to answer: keep_alive 0 unloads
+// the model, anything else loads it. It returns the done_reason to report.
+func loadModel(model string, keepAlive *KeepAlive) string {
+	d := keepAliveFor(keepAlive)
+	if d == 0 {
+		residentModel.Unload()
+		return doneUnload
+	}
+	useModel(model, d)()
+	return doneLoad
+}
+
+// generateRequest builds the q request for a generate call from its rendered
+// prompt, with the defaults of the requested model applied
+func generateRequest(req GenerateRequest, prompt string) QRequest {
+	model := resolveModel(req.Model)
+	return QRequest{Prompt: prompt, Images: req.Images, Options: mergeOptions(model.Options, req.Options), Flags: model.QFlags}
+}
+
+// saveGenerateContext records the exchange and returns the context handle
+// for the response. Raw requests do not carry conversation state.
+func saveGenerateContext(req GenerateRequest, history []conversationTurn, response string) []int {
+	if req.Raw {
+		return nil
+	}
+	return conversations.Save(history, conversationTurn{Prompt: req.Prompt, Response: response})
+}
+
+// respondError writes a backend failure as an Ollama-style error body with
+// a machine-readable code
+func respondError(c *gin.Context, err error) {
+	status, code := errorStatus(err)
+	setRetryAfter(c, status)
+	body := gin.H{"error": err.Error(), "code": code}
+	var jsonErr *JSONModeError
+	if errors.As(err, &jsonErr) && len(jsonErr.Violations) > 0 {
+		body["violations"] = jsonErr.Violations
+	}
+	c.JSON(status, body)
+}
 
-	cmd.Wait()
+// setRetryAfter tells clients when to retry a request that was turned away
+func setRetryAfter(c *gin.Context, status int) {
+	if status == http.StatusServiceUnavailable || status == http.StatusTooManyRequests {
+		c.Header("Retry-After", retryAfterSeconds)
+	}
+}
+
+// streamEnabled reports whether a request should stream. As in Ollama, an
+// omitted "stream" field means true.
+func streamEnabled(stream *bool) bool {
+	return stream == nil || *stream
+}
+
+// writeStreamError reports a failure after streaming has started as an
+// NDJSON error line, the way Ollama does once headers are sent
+func writeStreamError(c *gin.Context, err error) {
+	_, code := errorStatus(err)
+	writeNDJSON(c, gin.H{"error": err.Error(), "code": code})
+}
+
+// writeNDJSON writes v as a single NDJSON line and flushes it to the client
+func writeNDJSON(c *gin.Context, v interface{}) error {
+	if !c.Writer.Written() {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Transfer-Encoding", "chunked")
+	}
+
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Writer.Write(append(jsonData, '\n')); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
 }
 
 // Handle /api/chat endpoint
@@ -301,66 +413,47 @@ func handleChat(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Extract the last user message and any images
-	var userMessage string
-	var images []string
-	for i := len(req.Messages) - 1; i >= 0; i-- {
-		if req.Messages[i].Role == "user" {
-			userMessage = req.Messages[i].Content
-			images = req.Messages[i].Images
-			break
-		}
+	warnIgnoredOptions(c, req.Options)
+	if _, err := registry.Resolve(req.Model); err != nil {
+		respondError(c, err)
+		return
 	}
 
-	if userMessage == "" {
+	if userMessage, _ := lastUserMessage(req.Messages); userMessage == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No user message found"})
 		return
 	}
 
-	startTime := time.Now()
-	response, err := executeQCommand(userMessage, images)
+	qreq := chatRequest(req)
+	ctx, stats := newRequestStats(c.Request.Context(), qreq.Prompt)
+	response, err := generateFormatted(ctx, qreq, req.Format)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
-	duration := time.Since(startTime)
 
-	c.JSON(http.StatusOK, ChatResponse{
-		Model: "amazon-q",
-		Message: Message{
-			Role:    "assistant",
-			Content: response,
-		},
-		Done:          true,
-		TotalDuration: duration.Nanoseconds(),
-		EvalCount:     len(strings.Fields(response)),
-		EvalDuration:  duration.Nanoseconds(),
-		CreatedAt:     time.Now(),
-	})
+	final := ChatResponse{
+		Model:     responseModel(req.Model),
+		Message:   assistantMessage(response, req.Tools),
+		Done:      true,
+		CreatedAt: time.Now(),
+	}
+	stats.finish(response, doneStop).applyChat(&final)
+	c.JSON(http.StatusOK, final)
 }
 
 // Handle /api/tags endpoint
 func handleTags(c *gin.Context) {
 	c.JSON(http.StatusOK, TagsResponse{
-		Models: []ModelInfo{
-			{
-				Name:       "amazon-q:latest",
-				Model:      "amazon-q",
-				ModifiedAt: time.Now(),
-				Size:       0, // Amazon Q is a service, not a local model
-				Digest:     "sha256:amazon-q-service",
-				Details: ModelDetails{
-					Format:            "amazon-q-service",
-					Family:            "amazon-q",
-					ParameterSize:     "unknown",
-					QuantizationLevel: "unknown",
-				},
-			},
-		},
+		Models: availableModels(),
 	})
 }
 
+// availableModels lists the models served by this proxy
+func availableModels() []ModelInfo {
+	return registry.List()
+}
+
 // Handle /api/create endpoint
 func handleCreate(c *gin.Context) {
 	var req CreateRequest
@@ -369,9 +462,53 @@ func handleCreate(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusNotImplemented, gin.H{
-		"error": "Model creation not supported for Amazon Q service",
-	})
+	name := req.Model
+	if name == "" {
+		name = req.Name
+	}
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+	mf, err := req.modelfile()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	model, err := mf.virtualModel(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := registry.Check(model); err != nil {
+		var notFound *ModelNotFoundError
+		if errors.As(err, &notFound) {
+			respondError(c, err)
+		} else {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if !streamEnabled(req.Stream) {
+		if err := registry.Put(model); err != nil {
+			respondError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+		return
+	}
+
+	if req.Modelfile != "" {
+		writeNDJSON(c, gin.H{"status": "parsing modelfile"})
+	}
+	writeNDJSON(c, gin.H{"status": "using base model " + normalizeModelName(model.From)})
+	writeNDJSON(c, gin.H{"status": "writing manifest"})
+	if err := registry.Put(model); err != nil {
+		writeStreamError(c, err)
+		return
+	}
+	writeNDJSON(c, gin.H{"status": "success"})
 }
 
 // Handle /api/pull endpoint
@@ -434,16 +571,12 @@ func handleShow(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, ShowResponse{
-		Modelfile: "# Amazon Q Service Model\nFROM amazon-q-service",
-		Template:  "{{ .Prompt }}",
-		Details: ModelDetails{
-			Format:            "amazon-q-service",
-			Family:            "amazon-q",
-			ParameterSize:     "unknown",
-			QuantizationLevel: "unknown",
-		},
-	})
+	show, err := showModel(req.Name)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, show)
 }
 
 // Handle /api/embeddings endpoint
@@ -506,7 +639,7 @@ func handleUpload(c *gin.Context) {
 	defer file.Close()
 
 	// Create temporary file
-	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("q_upload_%d_%s", time.Now().Unix(), header.Filename))
+	tempFile := filepath.Join(os.TempDir(), fmt.Sprintf("%s%d_%s", uploadPrefix, time.Now().Unix(), header.Filename))
 	out, err := os.Create(tempFile)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temporary file"})
@@ -540,45 +673,72 @@ type ProcessResponse struct {
 
 type PsResponse struct {
 	Models []RunningModel `json:"models"`
+	Queue  *QueueStatus   `json:"queue,omitempty"`
 }
 
 type RunningModel struct {
-	Name       string    `json:"name"`
-	Model      string    `json:"model"`
-	Size       int64     `json:"size"`
-	Digest     string    `json:"digest"`
-	Details    ModelDetails `json:"details"`
-	ExpiresAt  time.Time `json:"expires_at"`
-	SizeVram   int64     `json:"size_vram"`
+	Name      string       `json:"name"`
+	Model     string       `json:"model"`
+	Size      int64        `json:"size"`
+	Digest    string       `json:"digest"`
+	Details   ModelDetails `json:"details"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	SizeVram  int64        `json:"size_vram"`
 }
 
 type StatusResponse struct {
-	Status string `json:"status"`
+	Status string         `json:"status"`
 	Models []RunningModel `json:"models,omitempty"`
 }
 
 // Handle /api/ps endpoint - List running models
 func handlePs(c *gin.Context) {
+	queue := admission.Status()
 	c.JSON(http.StatusOK, PsResponse{
-		Models: []RunningModel{
-			{
-				Name:      "amazon-q:latest",
-				Model:     "amazon-q",
-				Size:      0,
-				Digest:    "sha256:amazon-q-service",
-				ExpiresAt: time.Now().Add(24 * time.Hour),
-				SizeVram:  0,
-				Details: ModelDetails{
-					Format:            "amazon-q-service",
-					Family:            "amazon-q",
-					ParameterSize:     "unknown",
-					QuantizationLevel: "unknown",
-				},
-			},
-		},
+		Models: runningModels(),
+		Queue:  &queue,
 	})
 }
 
+// runningModels lists the models that are currently loaded: those used
+// since q was loaded, all sharing its expiry
+func runningModels() []RunningModel {
+	loaded, expiresAt := residentModel.Status()
+	if !loaded {
+		return []RunningModel{}
+	}
+	used := registry.usedSince(residentModel.LoadedAt())
+	if len(used) == 0 {
+		used = []ModelInfo{baseModelInfo()}
+	}
+	running := make([]RunningModel, 0, len(used))
+	for _, m := range used {
+		running = append(running, RunningModel{
+			Name:      m.Name,
+			Model:     m.Model,
+			Size:      m.Size,
+			Digest:    m.Digest,
+			ExpiresAt: expiresAt,
+			SizeVram:  0,
+			Details:   m.Details,
+		})
+	}
+	return running
+}
+
+// Handle /metrics endpoint - Prometheus text format
+func handleMetrics(c *gin.Context) {
+	queue := admission.Status()
+	var b strings.Builder
+	b.WriteString("# Amazon Q OLLAMA Metrics\n")
+	b.WriteString("amazon_q_ollama_up 1\n")
+	fmt.Fprintf(&b, "amazon_q_ollama_requests_active %d\n", queue.Active)
+	fmt.Fprintf(&b, "amazon_q_ollama_queue_depth %d\n", queue.Queued)
+	fmt.Fprintf(&b, "amazon_q_ollama_queue_capacity %d\n", queue.MaxQueue)
+	fmt.Fprintf(&b, "amazon_q_ollama_parallel_limit %d\n", queue.Parallel)
+	c.String(http.StatusOK, b.String())
+}
+
 // Handle /api/embed endpoint - Generate embeddings (alternative to /api/embeddings)
 func handleEmbed(c *gin.Context) {
 	var req EmbeddingsRequest
@@ -601,80 +761,71 @@ func handleList(c *gin.Context) {
 func handleStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, StatusResponse{
 		Status: "running",
-		Models: []RunningModel{
-			{
-				Name:      "amazon-q:latest",
-				Model:     "amazon-q",
-				Size:      0,
-				Digest:    "sha256:amazon-q-service",
-				ExpiresAt: time.Now().Add(24 * time.Hour),
-				SizeVram:  0,
-				Details: ModelDetails{
-					Format:            "amazon-q-service",
-					Family:            "amazon-q",
-					ParameterSize:     "unknown",
-					QuantizationLevel: "unknown",
-				},
-			},
-		},
+		Models: runningModels(),
 	})
 }
 
 // Handle streaming chat endpoint
 func handleChatStream(c *gin.Context, req ChatRequest) {
-	c.Header("Content-Type", "application/x-ndjson")
-	c.Header("Transfer-Encoding", "chunked")
-
-	// Extract the last user message and any images
-	var userMessage string
-	for i := len(req.Messages) - 1; i >= 0; i-- {
-		if req.Messages[i].Role == "user" {
-			userMessage = req.Messages[i].Content
-			break
-		}
-	}
-
-	if userMessage == "" {
+	if userMessage, _ := lastUserMessage(req.Messages); userMessage == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No user message found"})
 		return
 	}
 
-	args := []string{"chat", "--message", userMessage}
-	cmd := exec.Command("q", args...)
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	qreq := chatRequest(req)
+	ctx, stats := newRequestStats(c.Request.Context(), qreq.Prompt)
+	if len(req.Tools) > 0 || req.Format.enabled() {
+		// Tool calls and formatted output are only recognisable in the
+		// complete output, so the reply is sent as a single chunk
+		response, err := generateFormatted(ctx, qreq, req.Format)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		writeNDJSON(c, ChatResponse{
+			Model:     responseModel(req.Model),
+			Message:   assistantMessage(response, req.Tools),
+			Done:      false,
+			CreatedAt: time.Now(),
+		})
+		final := ChatResponse{
+			Model:     responseModel(req.Model),
+			Message:   Message{Role: "assistant"},
+			Done:      true,
+			CreatedAt: time.Now(),
+		}
+		stats.finish(response, doneStop).applyChat(&final)
+		writeNDJSON(c, final)
 		return
 	}
 
-	if err := cmd.Start(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	started := false
+	var response strings.Builder
+	err := stream(ctx, qreq, func(chunk string) error {
+		started = true
+		response.WriteString(chunk)
+		return writeNDJSON(c, ChatResponse{
+			Model: responseModel(req.Model),
+			Message: Message{
+				Role:    "assistant",
+				Content: chunk,
+			},
+			Done:      false,
+			CreatedAt: time.Now(),
+		})
+	})
+	if err != nil && !started {
+		respondError(c, err)
 		return
 	}
-
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line != "" {
-			response := ChatResponse{
-				Model: "amazon-q",
-				Message: Message{
-					Role:    "assistant",
-					Content: line,
-				},
-				Done:      false,
-				CreatedAt: time.Now(),
-			}
-			jsonData, _ := json.Marshal(response)
-			c.Writer.Write(jsonData)
-			c.Writer.Write([]byte("\n"))
-			c.Writer.Flush()
-		}
+	reason := doneReason(ctx, err)
+	if reason == doneError {
+		writeStreamError(c, err)
 	}
 
 	// Send final response
-	finalResponse := ChatResponse{
-		Model: "amazon-q",
+	final := ChatResponse{
+		Model: responseModel(req.Model),
 		Message: Message{
 			Role:    "assistant",
 			Content: "",
@@ -682,12 +833,8 @@ func handleChatStream(c *gin.Context, req ChatRequest) {
 		Done:      true,
 		CreatedAt: time.Now(),
 	}
-	jsonData, _ := json.Marshal(finalResponse)
-	c.Writer.Write(jsonData)
-	c.Writer.Write([]byte("\n"))
-	c.Writer.Flush()
-
-	cmd.Wait()
+	stats.finish(response.String(), reason).applyChat(&final)
+	writeNDJSON(c, final)
 }
 
 // Update handleChat to support streaming
@@ -697,48 +844,51 @@ func handleChatWithStreaming(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	warnIgnoredOptions(c, req.Options)
+	if _, err := registry.Resolve(req.Model); err != nil {
+		respondError(c, err)
+		return
+	}
 
-	if req.Stream {
-		handleChatStream(c, req)
+	// A chat without messages only loads or unloads the model
+	if len(req.Messages) == 0 && req.Model != "" {
+		c.JSON(http.StatusOK, ChatResponse{
+			Model:      responseModel(req.Model),
+			Message:    Message{Role: "assistant"},
+			Done:       true,
+			DoneReason: loadModel(req.Model, req.KeepAlive),
+			CreatedAt:  time.Now(),
+		})
 		return
 	}
+	defer useModel(req.Model, keepAliveFor(req.KeepAlive))()
 
-	// Extract the last user message and any images
-	var userMessage string
-	var images []string
-	for i := len(req.Messages) - 1; i >= 0; i-- {
-		if req.Messages[i].Role == "user" {
-			userMessage = req.Messages[i].Content
-			images = req.Messages[i].Images
-			break
-		}
+	if streamEnabled(req.Stream) {
+		handleChatStream(c, req)
+		return
 	}
 
-	if userMessage == "" {
+	if userMessage, _ := lastUserMessage(req.Messages); userMessage == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No user message found"})
 		return
 	}
 
-	startTime := time.Now()
-	response, err := executeQCommand(userMessage, images)
+	qreq := chatRequest(req)
+	ctx, stats := newRequestStats(c.Request.Context(), qreq.Prompt)
+	response, err := generateFormatted(ctx, qreq, req.Format)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondError(c, err)
 		return
 	}
-	duration := time.Since(startTime)
 
-	c.JSON(http.StatusOK, ChatResponse{
-		Model: "amazon-q",
-		Message: Message{
-			Role:    "assistant",
-			Content: response,
-		},
-		Done:          true,
-		TotalDuration: duration.Nanoseconds(),
-		EvalCount:     len(strings.Fields(response)),
-		EvalDuration:  duration.Nanoseconds(),
-		CreatedAt:     time.Now(),
-	})
+	final := ChatResponse{
+		Model:     responseModel(req.Model),
+		Message:   assistantMessage(response, req.Tools),
+		Done:      true,
+		CreatedAt: time.Now(),
+	}
+	stats.finish(response, doneStop).applyChat(&final)
+	c.JSON(http.StatusOK, final)
 }
 
 // CORS middleware for browser compatibility
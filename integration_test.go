@@ -88,7 +88,7 @@ func (suite *IntegrationTestSuite) TestAllEndpointsRespond() {
 		{"GET", "/api/ps", nil, 200},
 		{"GET", "/api/status", nil, 200},
 		{"POST", "/api/show", ShowRequest{Name: "amazon-q"}, 200},
-		{"POST", "/api/create", CreateRequest{Name: "test"}, 501},
+		{"POST", "/api/create", CreateRequest{Name: "test"}, 400},
 		{"POST", "/api/pull", PullRequest{Name: "test"}, 501},
 		{"POST", "/api/push", PushRequest{Name: "test"}, 501},
 		{"DELETE", "/api/delete", DeleteRequest{Name: "test"}, 501},
@@ -98,6 +98,8 @@ func (suite *IntegrationTestSuite) TestAllEndpointsRespond() {
 		{"GET", "/api/blobs/sha256:test", nil, 404},
 		{"HEAD", "/api/blobs/sha256:test", nil, 404},
 		{"POST", "/api/blobs/sha256:test", nil, 501},
+		{"GET", "/v1/models", nil, 200},
+		{"GET", "/v1/models/amazon-q:latest", nil, 200},
 	}
 
 	for _, endpoint := range endpoints {
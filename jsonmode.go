@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// formatJSON is the format value that asks for a JSON response
+const formatJSON = "json"
+
+// jsonInstruction is appended to the prompt in JSON mode
+const jsonInstruction = "\n\nRespond with only a single valid JSON value. Do not wrap it in markdown code fences and do not add any text before or after it."
+
+// schemaInstruction follows jsonInstruction when format is a JSON Schema
+const schemaInstruction = "\nThe JSON must match this JSON Schema:\n%s"
+
+// jsonRetryPrompt asks q again after an answer that held no usable JSON or
+// did not match the schema
+const jsonRetryPrompt = "\n\nYour previous reply was rejected because %v:\n%s\n\nReply again with only the corrected JSON."
+
+var (
+	codeFencePattern     = regexp.MustCompile("(?s)```[A-Za-z]*[ \\t]*\\n(.*?)```")
+	trailingCommaPattern = regexp.MustCompile(`,(\s*[}\]])`)
+)
+
+// Format is the format field of a request: the string "json", or a JSON
+// Schema object the response must match
+type Format struct {
+	// Type is "json" when a JSON response is required, empty otherwise
+	Type string
+	// Schema is the schema the response must match, if one was given
+	Schema *Schema
+	raw    json.RawMessage
+}
+
+func (f *Format) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		if name != "" && name != formatJSON {
+			return fmt.Errorf("unsupported format %q; use %q or a JSON Schema object", name, formatJSON)
+		}
+		*f = Format{Type: name}
+		return nil
+	}
+	schema, err := parseSchema(data)
+	if err != nil {
+		return fmt.Errorf("invalid format schema: %v", err)
+	}
+	*f = Format{Type: formatJSON, Schema: schema, raw: append(json.RawMessage(nil), data...)}
+	return nil
+}
+
+func (f Format) MarshalJSON() ([]byte, error) {
+	if f.Schema != nil {
+		return f.raw, nil
+	}
+	return json.Marshal(f.Type)
+}
+
+// enabled reports whether the response must be JSON
+func (f *Format) enabled() bool {
+	return f != nil && f.Type == formatJSON
+}
+
+// instruction is what the prompt is extended with to ask for the format
+func (f *Format) instruction() string {
+	if f.Schema == nil {
+		return jsonInstruction
+	}
+	var schema bytes.Buffer
+	if err := json.Compact(&schema, f.raw); err != nil {
+		schema.Write(f.raw)
+	}
+	return jsonInstruction + fmt.Sprintf(schemaInstruction, schema.String())
+}
+
+// JSONModeError reports that q gave no valid JSON, or no JSON matching the
+// schema, in any attempt. Violations lists the schema failures of the last
+// attempt when it was valid JSON.
+type JSONModeError struct {
+	Attempts   int
+	Err        error
+	Violations []SchemaViolation
+}
+
+func (e *JSONModeError) Error() string {
+	if len(e.Violations) > 0 {
+		return fmt.Sprintf("Amazon Q did not return JSON matching the schema after %d attempts: %v", e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("Amazon Q did not return valid JSON after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *JSONModeError) Unwrap() error {
+	return e.Err
+}
+
+// generateFormatted runs req through the backend and enforces format. q
+// cannot be constrained while it generates, so in JSON mode the JSON is
+// extracted from its answer and checked against the schema, and q is asked
+// again, told what was wrong, when that fails.
+func generateFormatted(ctx context.Context, req QRequest, format *Format) (string, error) {
+	if !format.enabled() {
+		return generate(ctx, req)
+	}
+
+	prompt := req.Prompt + format.instruction()
+	req.Prompt = prompt
+	attempts := config.JSONRetries + 1
+	var lastErr error
+	var violations []SchemaViolation
+	for attempt := 0; attempt < attempts; attempt++ {
+		response, err := generate(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		value, err := extractJSON(response)
+		violations = nil
+		if err == nil && format.Schema != nil {
+			if violations = format.Schema.Validate(value); len(violations) > 0 {
+				err = &schemaMismatchError{Violations: violations}
+			}
+		}
+		if err == nil {
+			return value, nil
+		}
+		lastErr = err
+		req.Prompt = prompt + fmt.Sprintf(jsonRetryPrompt, err, response)
+	}
+	return "", &JSONModeError{Attempts: attempts, Err: lastErr, Violations: violations}
+}
+
+// extractJSON finds the JSON value in q's answer. Fenced code blocks are
+// tried first, then the whole answer; prose around the value is ignored and
+// trailing commas are repaired.
+func extractJSON(output string) (string, error) {
+	var candidates []string
+	for _, match := range codeFencePattern.FindAllStringSubmatch(output, -1) {
+		candidates = append(candidates, strings.TrimSpace(match[1]))
+	}
+	candidates = append(candidates, strings.TrimSpace(output))
+
+	err := errors.New("no JSON object or array found")
+	for _, candidate := range candidates {
+		if json.Valid([]byte(candidate)) {
+			return candidate, nil
+		}
+		for _, text := range []string{candidate, trailingCommaPattern.ReplaceAllString(candidate, "$1")} {
+			value, decodeErr := firstJSONValue(text)
+			if decodeErr == nil {
+				return value, nil
+			}
+			if !errors.Is(decodeErr, errNoJSONValue) {
+				err = decodeErr
+			}
+		}
+	}
+	return "", err
+}
+
+var errNoJSONValue = errors.New("no JSON value")
+
+// firstJSONValue returns the first complete JSON object or array in text
+func firstJSONValue(text string) (string, error) {
+	err := errNoJSONValue
+	for i := 0; i < len(text); i++ {
+		if text[i] != '{' && text[i] != '[' {
+			continue
+		}
+		var value json.RawMessage
+		decodeErr := json.NewDecoder(strings.NewReader(text[i:])).Decode(&value)
+		if decodeErr == nil {
+			return string(value), nil
+		}
+		if err == errNoJSONValue {
+			err = decodeErr
+		}
+	}
+	return "", err
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sequenceBackend answers each Generate call with the next scripted response
+type sequenceBackend struct {
+	fakeBackend
+	responses []string
+}
+
+func (s *sequenceBackend) Generate(ctx context.Context, req QRequest) (string, error) {
+	s.requests = append(s.requests, req)
+	response := s.responses[0]
+	if len(s.responses) > 1 {
+		s.responses = s.responses[1:]
+	}
+	return response, nil
+}
+
+// useJSONRetries sets how many times JSON mode asks q again during a test
+func useJSONRetries(t *testing.T, n int) {
+	previous := config.JSONRetries
+	config.JSONRetries = n
+	t.Cleanup(func() { config.JSONRetries = previous })
+}
+
+func TestExtractJSON(t *testing.T) {
+	cases := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{"bare object", `{"a": 1}`, `{"a": 1}`},
+		{"bare array", `[1, 2]`, `[1, 2]`},
+		{"code fence", "```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"fence with prose", "Here you go:\n```\n{\"a\": 1}\n```\nHope this helps!", `{"a": 1}`},
+		{"leading and trailing text", `Sure! {"a": {"b": [1, 2]}} Let me know.`, `{"a": {"b": [1, 2]}}`},
+		{"trailing commas", "{\"a\": [1, 2,],\n \"b\": 3,\n}", "{\"a\": [1, 2],\n \"b\": 3\n}"},
+		{"brace in prose first", `Use {curly} braces: {"a": 1}`, `{"a": 1}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := extractJSON(tc.output)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err := extractJSON("I cannot answer that.")
+	assert.EqualError(t, err, "no JSON object or array found")
+	_, err = extractJSON(`{"a": `)
+	assert.Error(t, err)
+}
+
+func TestGenerateFormattedRetries(t *testing.T) {
+	useJSONRetries(t, 2)
+	fake := &sequenceBackend{responses: []string{"Sure, here is the data.", `{"name": "Ada"}`}}
+	useBackend(t, fake)
+
+	response, err := generateFormatted(context.Background(), QRequest{Prompt: "Describe Ada"}, &Format{Type: formatJSON})
+
+	require.NoError(t, err)
+	assert.Equal(t, `{"name": "Ada"}`, response)
+	require.Len(t, fake.requests, 2)
+	assert.Equal(t, "Describe Ada"+jsonInstruction, fake.requests[0].Prompt)
+	assert.Contains(t, fake.requests[1].Prompt, "Your previous reply was rejected because no JSON object or array found")
+	assert.Contains(t, fake.requests[1].Prompt, "Sure, here is the data.")
+}
+
+func TestGenerateFormattedGivesUp(t *testing.T) {
+	useJSONRetries(t, 1)
+	fake := &sequenceBackend{responses: []string{"no json here"}}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "hi", Format: &Format{Type: formatJSON}, Stream: boolPtr(false)})
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"invalid_json"`)
+	assert.Contains(t, w.Body.String(), "after 2 attempts")
+	assert.Len(t, fake.requests, 2)
+}
+
+func TestJSONModeEndpoints(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "```json\n{\"ok\": true}\n```"})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "hi", Format: &Format{Type: formatJSON}, Stream: boolPtr(false)})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"response":"{\"ok\": true}"`)
+
+	w = postJSON(t, "/api/chat", ChatRequest{
+		Model:    "amazon-q",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+		Format:   &Format{Type: formatJSON},
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 2, "a formatted reply streams as a single chunk")
+	assert.Equal(t, `{"ok": true}`, frames[0]["message"].(map[string]interface{})["content"])
+	assert.Equal(t, true, frames[1]["done"])
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "unused"})
+
+	w := postJSON(t, "/api/generate", map[string]interface{}{"model": "amazon-q", "prompt": "hi", "format": "yaml"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "unsupported format")
+
+	w = postJSON(t, "/api/generate", map[string]interface{}{"model": "amazon-q", "prompt": "hi", "format": map[string]interface{}{"type": "text"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `unknown type \"text\"`)
+}
+
+// personSchema is the format used by the schema tests
+const personSchema = `{"type": "object", "properties": {"name": {"type": "string"}, "age": {"type": "integer"}}, "required": ["name", "age"]}`
+
+func TestFormatUnmarshal(t *testing.T) {
+	var req GenerateRequest
+	require.NoError(t, json.Unmarshal([]byte(`{"format": "json"}`), &req))
+	assert.True(t, req.Format.enabled())
+	assert.Nil(t, req.Format.Schema)
+
+	req = GenerateRequest{}
+	require.NoError(t, json.Unmarshal([]byte(`{"format": ""}`), &req))
+	assert.False(t, req.Format.enabled())
+
+	req = GenerateRequest{}
+	require.NoError(t, json.Unmarshal([]byte(`{"format": `+personSchema+`}`), &req))
+	assert.True(t, req.Format.enabled())
+	require.NotNil(t, req.Format.Schema)
+	assert.Equal(t, []string{"name", "age"}, req.Format.Schema.Required)
+	assert.Contains(t, req.Format.instruction(), `"required":["name","age"]`)
+
+	data, err := json.Marshal(req)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"format":{"type":"object"`)
+}
+
+func TestGenerateFormattedSchemaRetries(t *testing.T) {
+	useJSONRetries(t, 2)
+	fake := &sequenceBackend{responses: []string{`{"name": "Ada", "age": "36"}`, `{"name": "Ada", "age": 36}`}}
+	useBackend(t, fake)
+	var format Format
+	require.NoError(t, json.Unmarshal([]byte(personSchema), &format))
+
+	response, err := generateFormatted(context.Background(), QRequest{Prompt: "Describe Ada"}, &format)
+
+	require.NoError(t, err)
+	assert.Equal(t, `{"name": "Ada", "age": 36}`, response)
+	require.Len(t, fake.requests, 2)
+	assert.Contains(t, fake.requests[0].Prompt, "must match this JSON Schema")
+	assert.Contains(t, fake.requests[1].Prompt, "$.age: expected integer, got string")
+}
+
+func TestSchemaMismatchResponse(t *testing.T) {
+	useJSONRetries(t, 0)
+	useBackend(t, &fakeBackend{response: `{"name": 7}`})
+
+	w := postJSON(t, "/api/chat", map[string]interface{}{
+		"model":    "amazon-q",
+		"messages": []Message{{Role: "user", Content: "hi"}},
+		"format":   json.RawMessage(personSchema),
+		"stream":   false,
+	})
+
+	require.Equal(t, http.StatusBadGateway, w.Code)
+	var body struct {
+		Code       string            `json:"code"`
+		Violations []SchemaViolation `json:"violations"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "schema_mismatch", body.Code)
+	assert.Equal(t, []SchemaViolation{
+		{Path: "$.age", Message: "is required"},
+		{Path: "$.name", Message: "expected string, got number"},
+	}, body.Violations)
+}
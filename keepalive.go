@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// neverExpires is reported as the expiry of a model kept loaded indefinitely
+const neverExpires = time.Duration(math.MaxInt64)
+
+// KeepAlive is Ollama's keep_alive value: a duration string such as "10m",
+// a number of seconds, or a negative value to keep the model loaded forever
+type KeepAlive struct {
+	time.Duration
+}
+
+func (k *KeepAlive) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case json.Number:
+		seconds, err := v.Float64()
+		if err != nil {
+			return fmt.Errorf("invalid keep_alive %s: %v", v, err)
+		}
+		k.Duration = time.Duration(seconds * float64(time.Second))
+	case string:
+		d, err := parseKeepAlive(v)
+		if err != nil {
+			return err
+		}
+		k.Duration = d
+	default:
+		return fmt.Errorf("invalid keep_alive %s", data)
+	}
+	if k.Duration < 0 {
+		k.Duration = -1
+	}
+	return nil
+}
+
+// parseKeepAlive accepts a Go duration or a bare number of seconds
+func parseKeepAlive(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err == nil {
+		return d, nil
+	}
+	seconds, numErr := strconv.ParseFloat(value, 64)
+	if numErr != nil {
+		return 0, fmt.Errorf("invalid keep_alive %q: %v", value, err)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// keepAliveFor returns how long a request keeps the model loaded, falling
+// back to the configured default when the request does not say
+func keepAliveFor(k *KeepAlive) time.Duration {
+	if k == nil {
+		return config.KeepAlive
+	}
+	return k.Duration
+}
+
+// residentModel tracks whether amazon-q is loaded. Loading starts the warm
+// q worker pool when one is configured; unloading stops it.
+var residentModel = newResidency(applyPoolResidency)
+
+// residency tracks the lifetime of a loaded model. It loads on first use and
+// unloads once keep_alive has passed with no request in flight.
+type residency struct {
+	mu       sync.Mutex
+	loaded   bool
+	loadedAt time.Time
+	active   int
+	expires  time.Time
+	timer    *time.Timer
+	now      func() time.Time
+	// unloadIdle unloads the model as soon as the last request finishes
+	unloadIdle bool
+
+	// syncMu serialises apply so load and unload never interleave
+	syncMu  sync.Mutex
+	applied bool
+	apply   func(loaded bool)
+}
+
+func newResidency(apply func(loaded bool)) *residency {
+	return &residency{apply: apply, now: time.Now}
+}
+
+// Use marks the model as serving one request, loading it first if needed.
+// Calling done releases it; the model then stays loaded for keepAlive, is
+// unloaded at once when keepAlive is 0, and never expires when negative.
+func (r *residency) Use(keepAlive time.Duration) (done func()) {
+	r.mu.Lock()
+	r.active++
+	if !r.loaded {
+		r.loadedAt = r.now()
+	}
+	r.loaded = true
+	r.unloadIdle = false
+	r.setExpiry(keepAlive)
+	r.mu.Unlock()
+	r.sync()
+
+	var once sync.Once
+	return func() { once.Do(func() { r.release(keepAlive) }) }
+}
+
+func (r *residency) release(keepAlive time.Duration) {
+	r.mu.Lock()
+	r.active--
+	r.setExpiry(keepAlive)
+	if r.active == 0 {
+		switch {
+		case keepAlive == 0 || r.unloadIdle:
+			r.loaded = false
+			r.unloadIdle = false
+		case keepAlive > 0:
+			r.timer = time.AfterFunc(keepAlive, r.expire)
+		}
+	}
+	r.mu.Unlock()
+	r.sync()
+}
+
+// Unload drops the model now, or once the requests using it have finished
+func (r *residency) Unload() {
+	r.mu.Lock()
+	r.setExpiry(0)
+	if r.active == 0 {
+		r.loaded = false
+	} else {
+		r.unloadIdle = true
+	}
+	r.mu.Unlock()
+	r.sync()
+}
+
+// setExpiry records when the model expires; the caller holds r.mu
+func (r *residency) setExpiry(keepAlive time.Duration) {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	if keepAlive < 0 {
+		keepAlive = neverExpires
+	}
+	r.expires = r.now().Add(keepAlive)
+}
+
+// expire unloads the model if it has stayed idle past its expiry
+func (r *residency) expire() {
+	r.mu.Lock()
+	if r.active > 0 || !r.loaded || r.now().Before(r.expires) {
+		r.mu.Unlock()
+		return
+	}
+	r.loaded = false
+	r.mu.Unlock()
+	r.sync()
+}
+
+// sync brings whatever apply controls in line with the loaded state
+func (r *residency) sync() {
+	r.syncMu.Lock()
+	defer r.syncMu.Unlock()
+	r.mu.Lock()
+	loaded := r.loaded
+	r.mu.Unlock()
+	if loaded != r.applied && r.apply != nil {
+		r.apply(loaded)
+	}
+	r.applied = loaded
+}
+
+// Status reports whether the model is loaded and when it expires
+func (r *residency) Status() (loaded bool, expiresAt time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loaded, r.expires
+}
+
+// LoadedAt reports when the model was last loaded
+func (r *residency) LoadedAt() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loadedAt
+}
+
+// poolStart guards which pool start may install its pool: each load or
+// unload begins a new generation, and a start that finishes after another
+// one has begun discards its workers. pending counts starts still running.
+var poolStart struct {
+	sync.Mutex
+	generation int
+	pending    sync.WaitGroup
+}
+
+// applyPoolResidency starts the q worker pool when the model loads and
+// stops it when the model unloads. Workers are spawned in the background so
+// the request that loads the model does not wait for them; requests run
+// one-off q processes until the pool is installed.
+func applyPoolResidency(loaded bool) {
+	if config.PoolSize <= 0 {
+		return
+	}
+	poolStart.Lock()
+	poolStart.generation++
+	generation := poolStart.generation
+	var previous *qPool
+	if !loaded {
+		previous = setPool(nil)
+	}
+	poolStart.Unlock()
+
+	if !loaded {
+		if previous != nil {
+			previous.Close()
+		}
+		return
+	}
+	p := makeQPool(config.PoolSize, config.PoolMaxRequests, config.PoolHealthInterval, config.PoolResetCommand)
+	poolStart.pending.Add(1)
+	go func() {
+		defer poolStart.pending.Done()
+		p.start()
+		poolStart.Lock()
+		current := poolStart.generation == generation
+		if current {
+			setPool(p)
+		}
+		poolStart.Unlock()
+		if !current {
+			p.Close()
+		}
+	}()
+}
+
+// waitPoolStarts waits for pool starts begun so far to finish
+func waitPoolStarts() {
+	poolStart.pending.Wait()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useResidency swaps the resident model tracker for the duration of a test
+func useResidency(t *testing.T, r *residency) {
+	previous := residentModel
+	residentModel = r
+	t.Cleanup(func() { residentModel = previous })
+}
+
+// recordApply returns an apply hook that records every load and unload
+func recordApply() (apply func(bool), calls func() []bool) {
+	var mu sync.Mutex
+	var seen []bool
+	return func(loaded bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen = append(seen, loaded)
+		}, func() []bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]bool(nil), seen...)
+		}
+}
+
+func getPs(t *testing.T) PsResponse {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/ps", nil)
+	setupRouter().ServeHTTP(w, req)
+	var ps PsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ps))
+	return ps
+}
+
+func TestKeepAliveParsing(t *testing.T) {
+	cases := map[string]time.Duration{
+		`"10m"`: 10 * time.Minute,
+		`"30"`:  30 * time.Second,
+		`120`:   2 * time.Minute,
+		`0`:     0,
+		`"0s"`:  0,
+		`-1`:    -1,
+		`"-5m"`: -1,
+	}
+	for input, want := range cases {
+		var k KeepAlive
+		require.NoError(t, json.Unmarshal([]byte(input), &k), input)
+		assert.Equal(t, want, k.Duration, input)
+	}
+
+	var k KeepAlive
+	assert.Error(t, json.Unmarshal([]byte(`"soon"`), &k))
+	assert.Error(t, json.Unmarshal([]byte(`true`), &k))
+}
+
+func TestPreloadAndUnload(t *testing.T) {
+	apply, calls := recordApply()
+	useResidency(t, newResidency(apply))
+	assert.Empty(t, getPs(t).Models, "nothing is loaded before the first request")
+
+	w := postJSON(t, "/api/generate", map[string]interface{}{"model": "amazon-q", "keep_alive": "10m"})
+	assert.Equal(t, 200, w.Code)
+	var loaded GenerateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &loaded))
+	assert.True(t, loaded.Done)
+	assert.Equal(t, "load", loaded.DoneReason)
+
+	ps := getPs(t)
+	require.Len(t, ps.Models, 1)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), ps.Models[0].ExpiresAt, 5*time.Second)
+
+	w = postJSON(t, "/api/chat", map[string]interface{}{"model": "amazon-q", "messages": []Message{}, "keep_alive": 0})
+	assert.Equal(t, 200, w.Code)
+	var unloaded ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &unloaded))
+	assert.Equal(t, "unload", unloaded.DoneReason)
+
+	assert.Empty(t, getPs(t).Models)
+	assert.Equal(t, []bool{true, false}, calls())
+}
+
+func TestEmptyPromptWithoutModel(t *testing.T) {
+	useResidency(t, newResidency(nil))
+
+	w := postJSON(t, "/api/generate", map[string]interface{}{"prompt": ""})
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "model is required")
+}
+
+func TestRequestKeepAliveSetsExpiry(t *testing.T) {
+	useResidency(t, newResidency(nil))
+	useBackend(t, &fakeBackend{response: "ok"})
+
+	postJSON(t, "/api/generate", map[string]interface{}{"model": "amazon-q", "prompt": "hi", "stream": false, "keep_alive": "1h"})
+	ps := getPs(t)
+	require.Len(t, ps.Models, 1)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), ps.Models[0].ExpiresAt, 5*time.Second)
+
+	postJSON(t, "/api/generate", map[string]interface{}{"model": "amazon-q", "prompt": "hi", "stream": false, "keep_alive": -1})
+	ps = getPs(t)
+	require.Len(t, ps.Models, 1)
+	assert.True(t, ps.Models[0].ExpiresAt.After(time.Now().AddDate(100, 0, 0)), "negative keep_alive never expires")
+
+	postJSON(t, "/api/generate", map[string]interface{}{"model": "amazon-q", "prompt": "hi", "stream": false, "keep_alive": 0})
+	assert.Empty(t, getPs(t).Models, "keep_alive 0 unloads after the request")
+}
+
+func TestResidencyExpires(t *testing.T) {
+	apply, calls := recordApply()
+	r := newResidency(apply)
+
+	r.Use(20 * time.Millisecond)()
+	loaded, _ := r.Status()
+	assert.True(t, loaded)
+
+	assert.Eventually(t, func() bool {
+		loaded, _ := r.Status()
+		return !loaded
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, []bool{true, false}, calls())
+}
+
+func TestResidencyStaysLoadedWhileInUse(t *testing.T) {
+	apply, calls := recordApply()
+	r := newResidency(apply)
+
+	done := r.Use(time.Minute)
+	r.Unload()
+	loaded, _ := r.Status()
+	assert.True(t, loaded, "an in-flight request keeps the model loaded")
+
+	done()
+	loaded, _ = r.Status()
+	assert.False(t, loaded, "the pending unload happens once the request finishes")
+	assert.Equal(t, []bool{true, false}, calls())
+}
+
+func TestResidencyControlsPool(t *testing.T) {
+	useFakeQ(t, fakeInteractiveQ)
+	previous := config.PoolSize
+	config.PoolSize = 1
+	t.Cleanup(func() { config.PoolSize = previous })
+	r := newResidency(applyPoolResidency)
+
+	done := r.Use(time.Minute)
+	waitPoolStarts()
+	assert.NotNil(t, activePool(), "loading starts the worker pool")
+	done()
+
+	r.Unload()
+	assert.Nil(t, activePool(), "unloading stops the worker pool")
+}
+
+func TestPoolStartsOffTheRequestPath(t *testing.T) {
+	// Workers do not show their prompt until the gate file exists
+	gate := filepath.Join(t.TempDir(), "gate")
+	useFakeQ(t, "#!/bin/sh\nwhile [ ! -e '"+gate+"' ]; do sleep 0.01; done\n"+strings.TrimPrefix(fakeInteractiveQ, "#!/bin/sh\n"))
+	previous := config.PoolSize
+	config.PoolSize = 1
+	t.Cleanup(func() { config.PoolSize = previous })
+	r := newResidency(applyPoolResidency)
+
+	done := r.Use(time.Minute)
+	assert.Nil(t, activePool(), "requests run without the pool until it is ready")
+	done()
+
+	// An unload before the workers are ready discards them
+	r.Unload()
+	require.NoError(t, os.WriteFile(gate, nil, 0644))
+	waitPoolStarts()
+	assert.Nil(t, activePool())
+}
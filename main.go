@@ -1,13 +1,29 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	// "amazon-q-ollama mcp" serves MCP over stdio for hosts that launch it
+	if len(os.Args) > 1 && os.Args[1] == "mcp" {
+		if err := serveMCPStdio(context.Background(), os.Stdin, os.Stdout); err != nil {
+			log.Fatal("MCP server failed:", err)
+		}
+		return
+	}
+
+	// Warm the worker pool up front; it stays up for the default keep_alive
+	if config.PoolSize > 0 {
+		residentModel.Use(config.KeepAlive)()
+		log.Printf("Starting q chat worker pool with %d workers in the background", config.PoolSize)
+	}
+
 	r := gin.Default()
 
 	// Add CORS middleware for browser compatibility
@@ -24,30 +40,30 @@ func main() {
 		api.POST("/chat", handleChatWithStreaming)
 		api.GET("/tags", handleTags)
 		api.POST("/show", handleShow)
-		
+
 		// Model management endpoints (not supported but implemented for compatibility)
 		api.POST("/create", handleCreate)
 		api.POST("/pull", handlePull)
 		api.POST("/push", handlePush)
 		api.DELETE("/delete", handleDelete)
 		api.POST("/copy", handleCopy)
-		
+
 		// Process management endpoints
 		api.GET("/ps", handlePs)
 		api.GET("/status", handleStatus)
-		
+
 		// Embedding endpoints
 		api.POST("/embeddings", handleEmbeddings)
 		api.POST("/embed", handleEmbed)
-		
+
 		// Alternative endpoints
 		api.GET("/list", handleList)
-		
+
 		// Blob endpoints
 		api.GET("/blobs/:digest", handleBlobs)
 		api.HEAD("/blobs/:digest", handleBlobsHead)
 		api.POST("/blobs/:digest", handleBlobsPost)
-		
+
 		// Version endpoint
 		api.GET("/version", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{
@@ -56,6 +72,16 @@ func main() {
 		})
 	}
 
+	// OpenAI- and Anthropic-compatible endpoints
+	v1 := r.Group("/v1")
+	{
+		v1.POST("/chat/completions", handleOpenAIChatCompletions)
+		v1.POST("/completions", handleOpenAICompletions)
+		v1.GET("/models", handleOpenAIModels)
+		v1.GET("/models/:id", handleOpenAIModel)
+		v1.POST("/messages", handleAnthropicMessages)
+	}
+
 	// File upload endpoint
 	r.POST("/upload", handleUpload)
 
@@ -75,9 +101,12 @@ func main() {
 	})
 
 	// Metrics endpoint (basic)
-	r.GET("/metrics", func(c *gin.Context) {
-		c.String(http.StatusOK, "# Amazon Q OLLAMA Metrics\namazon_q_ollama_up 1\n")
-	})
+	r.GET("/metrics", handleMetrics)
+
+	// MCP server over streamable HTTP
+	r.POST("/mcp", handleMCP)
+	r.GET("/mcp", handleMCPMethodNotAllowed)
+	r.DELETE("/mcp", handleMCPMethodNotAllowed)
 
 	// Root endpoint
 	r.GET("/", func(c *gin.Context) {
@@ -108,6 +137,12 @@ func main() {
 				"GET /ping",
 				"HEAD /",
 				"GET /metrics",
+				"POST /v1/chat/completions",
+				"POST /v1/completions",
+				"GET /v1/models",
+				"GET /v1/models/:id",
+				"POST /v1/messages",
+				"POST /mcp",
 			},
 		})
 	})
@@ -117,7 +152,7 @@ func main() {
 	log.Println("Complete API compatibility with streaming support")
 	log.Println("CORS enabled for browser compatibility")
 	log.Println("Visit http://localhost:11434 for endpoint information")
-	
+
 	if err := r.Run(":11434"); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"mime/multipart"
@@ -47,6 +48,15 @@ func setupRouter() *gin.Engine {
 		})
 	}
 
+	v1 := r.Group("/v1")
+	{
+		v1.POST("/chat/completions", handleOpenAIChatCompletions)
+		v1.POST("/completions", handleOpenAICompletions)
+		v1.GET("/models", handleOpenAIModels)
+		v1.GET("/models/:id", handleOpenAIModel)
+		v1.POST("/messages", handleAnthropicMessages)
+	}
+
 	r.POST("/upload", handleUpload)
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "ok"})
@@ -57,9 +67,10 @@ func setupRouter() *gin.Engine {
 	r.HEAD("/", func(c *gin.Context) {
 		c.Status(http.StatusOK)
 	})
-	r.GET("/metrics", func(c *gin.Context) {
-		c.String(http.StatusOK, "# Amazon Q OLLAMA Metrics\namazon_q_ollama_up 1\n")
-	})
+	r.GET("/metrics", handleMetrics)
+	r.POST("/mcp", handleMCP)
+	r.GET("/mcp", handleMCPMethodNotAllowed)
+	r.DELETE("/mcp", handleMCPMethodNotAllowed)
 	r.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"message": "Amazon Q OLLAMA - OLLAMA Compatible API",
@@ -77,7 +88,7 @@ func TestHealthEndpoint(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response map[string]string
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -101,7 +112,7 @@ func TestRootEndpoint(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response map[string]interface{}
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -126,7 +137,7 @@ func TestVersionEndpoint(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response map[string]string
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -150,7 +161,7 @@ func TestTagsEndpoint(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response TagsResponse
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -166,7 +177,7 @@ func TestListEndpoint(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response TagsResponse
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -174,13 +185,15 @@ func TestListEndpoint(t *testing.T) {
 }
 
 func TestPsEndpoint(t *testing.T) {
+	useResidency(t, newResidency(nil))
+	postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q"})
 	router := setupRouter()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/ps", nil)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response PsResponse
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -189,13 +202,15 @@ func TestPsEndpoint(t *testing.T) {
 }
 
 func TestStatusEndpoint(t *testing.T) {
+	useResidency(t, newResidency(nil))
+	postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q"})
 	router := setupRouter()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/api/status", nil)
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response StatusResponse
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -205,45 +220,45 @@ func TestStatusEndpoint(t *testing.T) {
 
 func TestShowEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	showReq := ShowRequest{
 		Name:    "amazon-q",
 		Verbose: false,
 	}
 	jsonData, _ := json.Marshal(showReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/show", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response ShowResponse
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
 	assert.Contains(t, response.Modelfile, "Amazon Q Service Model")
-	assert.Equal(t, "{{ .Prompt }}", response.Template)
+	assert.Equal(t, defaultTemplate, response.Template)
 }
 
 func TestGenerateEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	genReq := GenerateRequest{
 		Model:  "amazon-q",
 		Prompt: "Hello world",
-		Stream: false,
+		Stream: boolPtr(false),
 	}
 	jsonData, _ := json.Marshal(genReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/generate", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	// Since we don't have actual Q CLI in test environment, expect error
-	assert.True(t, w.Code == 500 || w.Code == 200)
-	
+	assert.True(t, w.Code == 503 || w.Code == 500 || w.Code == 200)
+
 	if w.Code == 200 {
 		var response GenerateResponse
 		err := json.Unmarshal(w.Body.Bytes(), &response)
@@ -255,7 +270,7 @@ func TestGenerateEndpoint(t *testing.T) {
 
 func TestChatEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	chatReq := ChatRequest{
 		Model: "amazon-q",
 		Messages: []Message{
@@ -264,18 +279,18 @@ func TestChatEndpoint(t *testing.T) {
 				Content: "Hello",
 			},
 		},
-		Stream: false,
+		Stream: boolPtr(false),
 	}
 	jsonData, _ := json.Marshal(chatReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/chat", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	// Since we don't have actual Q CLI in test environment, expect error
-	assert.True(t, w.Code == 500 || w.Code == 200)
-	
+	assert.True(t, w.Code == 503 || w.Code == 500 || w.Code == 200)
+
 	if w.Code == 200 {
 		var response ChatResponse
 		err := json.Unmarshal(w.Body.Bytes(), &response)
@@ -287,7 +302,7 @@ func TestChatEndpoint(t *testing.T) {
 
 func TestChatEndpointNoUserMessage(t *testing.T) {
 	router := setupRouter()
-	
+
 	chatReq := ChatRequest{
 		Model: "amazon-q",
 		Messages: []Message{
@@ -298,14 +313,14 @@ func TestChatEndpointNoUserMessage(t *testing.T) {
 		},
 	}
 	jsonData, _ := json.Marshal(chatReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/chat", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 400, w.Code)
-	
+
 	var response map[string]string
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -313,35 +328,37 @@ func TestChatEndpointNoUserMessage(t *testing.T) {
 }
 
 func TestCreateEndpoint(t *testing.T) {
+	useRegistry(t, newModelRegistry(""))
 	router := setupRouter()
-	
+
 	createReq := CreateRequest{
 		Name:      "test-model",
 		Modelfile: "FROM amazon-q",
+		Stream:    boolPtr(false),
 	}
 	jsonData, _ := json.Marshal(createReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/create", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, 501, w.Code)
-	
+	assert.Equal(t, 200, w.Code)
+
 	var response map[string]string
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
-	assert.Contains(t, response["error"], "not supported")
+	assert.Equal(t, "success", response["status"])
 }
 
 func TestPullEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	pullReq := PullRequest{
 		Name: "test-model",
 	}
 	jsonData, _ := json.Marshal(pullReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/pull", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
@@ -352,12 +369,12 @@ func TestPullEndpoint(t *testing.T) {
 
 func TestPushEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	pushReq := PushRequest{
 		Name: "test-model",
 	}
 	jsonData, _ := json.Marshal(pushReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/push", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
@@ -368,12 +385,12 @@ func TestPushEndpoint(t *testing.T) {
 
 func TestDeleteEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	deleteReq := DeleteRequest{
 		Name: "test-model",
 	}
 	jsonData, _ := json.Marshal(deleteReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("DELETE", "/api/delete", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
@@ -384,13 +401,13 @@ func TestDeleteEndpoint(t *testing.T) {
 
 func TestCopyEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	copyReq := CopyRequest{
 		Source:      "source-model",
 		Destination: "dest-model",
 	}
 	jsonData, _ := json.Marshal(copyReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/copy", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
@@ -401,13 +418,13 @@ func TestCopyEndpoint(t *testing.T) {
 
 func TestEmbeddingsEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	embReq := EmbeddingsRequest{
 		Model:  "amazon-q",
 		Prompt: "Hello world",
 	}
 	jsonData, _ := json.Marshal(embReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/embeddings", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
@@ -418,13 +435,13 @@ func TestEmbeddingsEndpoint(t *testing.T) {
 
 func TestEmbedEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	embReq := EmbeddingsRequest{
 		Model:  "amazon-q",
 		Prompt: "Hello world",
 	}
 	jsonData, _ := json.Marshal(embReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/embed", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
@@ -462,36 +479,36 @@ func TestBlobsPostEndpoint(t *testing.T) {
 
 func TestUploadEndpoint(t *testing.T) {
 	router := setupRouter()
-	
+
 	// Create a temporary file for testing
 	content := "Hello, World!"
-	
+
 	// Create multipart form
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
 	part, err := writer.CreateFormFile("file", "test.txt")
 	assert.NoError(t, err)
-	
+
 	_, err = io.WriteString(part, content)
 	assert.NoError(t, err)
-	
+
 	err = writer.Close()
 	assert.NoError(t, err)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/upload", &buf)
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 200, w.Code)
-	
+
 	var response map[string]string
 	err = json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
 	assert.Equal(t, "File uploaded successfully", response["message"])
 	assert.Equal(t, "test.txt", response["filename"])
 	assert.Contains(t, response["path"], "test.txt")
-	
+
 	// Clean up the uploaded file
 	if path, exists := response["path"]; exists {
 		os.Remove(path)
@@ -500,14 +517,14 @@ func TestUploadEndpoint(t *testing.T) {
 
 func TestUploadEndpointNoFile(t *testing.T) {
 	router := setupRouter()
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/upload", nil)
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, 400, w.Code)
-	
+
 	var response map[string]string
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
@@ -529,7 +546,7 @@ func TestCORSHeaders(t *testing.T) {
 
 func TestInvalidJSONRequest(t *testing.T) {
 	router := setupRouter()
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/generate", strings.NewReader("invalid json"))
 	req.Header.Set("Content-Type", "application/json")
@@ -540,33 +557,33 @@ func TestInvalidJSONRequest(t *testing.T) {
 
 func TestGenerateWithImages(t *testing.T) {
 	router := setupRouter()
-	
+
 	// Simple base64 encoded 1x1 pixel PNG
 	base64Image := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8/5+hHgAHggJ/PchI7wAAAABJRU5ErkJggg=="
-	
+
 	genReq := GenerateRequest{
 		Model:  "amazon-q",
 		Prompt: "Describe this image",
 		Images: []string{base64Image},
-		Stream: false,
+		Stream: boolPtr(false),
 	}
 	jsonData, _ := json.Marshal(genReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/generate", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	// Since we don't have actual Q CLI, expect error or success
-	assert.True(t, w.Code == 500 || w.Code == 200)
+	assert.True(t, w.Code == 503 || w.Code == 500 || w.Code == 200)
 }
 
 func TestChatWithImages(t *testing.T) {
 	router := setupRouter()
-	
+
 	// Simple base64 encoded 1x1 pixel PNG
 	base64Image := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mP8/5+hHgAHggJ/PchI7wAAAABJRU5ErkJggg=="
-	
+
 	chatReq := ChatRequest{
 		Model: "amazon-q",
 		Messages: []Message{
@@ -576,37 +593,37 @@ func TestChatWithImages(t *testing.T) {
 				Images:  []string{base64Image},
 			},
 		},
-		Stream: false,
+		Stream: boolPtr(false),
 	}
 	jsonData, _ := json.Marshal(chatReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/chat", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	// Since we don't have actual Q CLI, expect error or success
-	assert.True(t, w.Code == 500 || w.Code == 200)
+	assert.True(t, w.Code == 503 || w.Code == 500 || w.Code == 200)
 }
 
 func TestStreamingGenerate(t *testing.T) {
 	router := setupRouter()
-	
+
 	genReq := GenerateRequest{
 		Model:  "amazon-q",
 		Prompt: "Count to 3",
-		Stream: true,
+		Stream: boolPtr(true),
 	}
 	jsonData, _ := json.Marshal(genReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/generate", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	// For streaming, we expect either success or error
-	assert.True(t, w.Code == 500 || w.Code == 200)
-	
+	assert.True(t, w.Code == 503 || w.Code == 500 || w.Code == 200)
+
 	if w.Code == 200 {
 		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
 	}
@@ -614,7 +631,7 @@ func TestStreamingGenerate(t *testing.T) {
 
 func TestStreamingChat(t *testing.T) {
 	router := setupRouter()
-	
+
 	chatReq := ChatRequest{
 		Model: "amazon-q",
 		Messages: []Message{
@@ -623,18 +640,18 @@ func TestStreamingChat(t *testing.T) {
 				Content: "Count to 3",
 			},
 		},
-		Stream: true,
+		Stream: boolPtr(true),
 	}
 	jsonData, _ := json.Marshal(chatReq)
-	
+
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("POST", "/api/chat", bytes.NewBuffer(jsonData))
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	// For streaming, we expect either success or error
-	assert.True(t, w.Code == 500 || w.Code == 200)
-	
+	assert.True(t, w.Code == 503 || w.Code == 500 || w.Code == 200)
+
 	if w.Code == 200 {
 		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
 	}
@@ -644,26 +661,26 @@ func TestStreamingChat(t *testing.T) {
 func TestExecuteQCommandWithInvalidImages(t *testing.T) {
 	// Test with invalid base64 data
 	invalidImages := []string{"invalid-base64-data"}
-	
+
 	// This should not panic and should handle invalid images gracefully
-	_, err := executeQCommand("test prompt", invalidImages)
-	
+	_, err := executeQCommand(context.Background(), "test prompt", invalidImages)
+
 	// We expect an error since Q CLI is not available in test environment
 	assert.Error(t, err)
 }
 
 func TestCorsMiddleware(t *testing.T) {
 	router := setupRouter()
-	
+
 	// Test preflight request
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("OPTIONS", "/api/chat", nil)
 	req.Header.Set("Origin", "http://example.com")
 	req.Header.Set("Access-Control-Request-Method", "POST")
 	req.Header.Set("Access-Control-Request-Headers", "Content-Type")
-	
+
 	router.ServeHTTP(w, req)
-	
+
 	assert.Equal(t, 204, w.Code)
 	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
 	assert.Contains(t, w.Header().Get("Access-Control-Allow-Methods"), "POST")
@@ -672,13 +689,13 @@ func TestCorsMiddleware(t *testing.T) {
 
 func TestResponseTiming(t *testing.T) {
 	router := setupRouter()
-	
+
 	start := time.Now()
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest("GET", "/health", nil)
 	router.ServeHTTP(w, req)
 	duration := time.Since(start)
-	
+
 	assert.Equal(t, 200, w.Code)
 	assert.Less(t, duration, 100*time.Millisecond, "Health endpoint should respond quickly")
 }
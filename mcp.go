@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mcpProtocolVersions lists the MCP revisions this server speaks, newest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// mcpMaxFileBytes caps the files review_file attaches for q and
+// resources/read returns
+const mcpMaxFileBytes = 1 << 20
+
+// uploadPrefix starts the name of every file saved by /upload
+const uploadPrefix = "q_upload_"
+
+// JSON-RPC error codes used by MCP
+const (
+	mcpParseError     = -32700
+	mcpInvalidRequest = -32600
+	mcpMethodNotFound = -32601
+	mcpInvalidParams  = -32602
+	mcpInternalError  = -32603
+)
+
+type mcpMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type mcpResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *mcpError       `json:"error,omitempty"`
+}
+
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *mcpError) Error() string {
+	return e.Message
+}
+
+type mcpTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type mcpToolResult struct {
+	Content []mcpContent `json:"content"`
+	IsError bool         `json:"isError,omitempty"`
+}
+
+type mcpResource struct {
+	URI      string `json:"uri"`
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType,omitempty"`
+	Size     int64  `json:"size"`
+}
+
+type mcpResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
+}
+
+var mcpTools = []mcpTool{
+	{
+		Name:        "ask_amazon_q",
+		Description: "Ask Amazon Q a question and return its answer.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"prompt": map[string]interface{}{"type": "string", "description": "The question or instruction for Amazon Q"},
+			},
+			"required": []string{"prompt"},
+		},
+	},
+	{
+		Name:        "review_file",
+		Description: "Have Amazon Q review a file for bugs, risks and possible improvements.",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"path":         map[string]interface{}{"type": "string", "description": "Path or file:// URI of the file to review"},
+				"instructions": map[string]interface{}{"type": "string", "description": "What the review should focus on"},
+			},
+			"required": []string{"path"},
+		},
+	},
+}
+
+// mcpServer answers MCP requests. Over HTTP it only reads files saved by
+// /upload; over stdio it runs on the host's behalf and may read any file.
+type mcpServer struct {
+	localFiles bool
+}
+
+// handle answers one JSON-RPC message, returning nil for notifications
+func (s *mcpServer) handle(ctx context.Context, msg mcpMessage) *mcpResponse {
+	if msg.ID == nil {
+		return nil
+	}
+	response := &mcpResponse{JSONRPC: "2.0", ID: msg.ID}
+	result, err := s.dispatch(ctx, msg)
+	if err != nil {
+		var rpcErr *mcpError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &mcpError{Code: mcpInternalError, Message: err.Error()}
+		}
+		response.Error = rpcErr
+		return response
+	}
+	response.Result = result
+	return response
+}
+
+func (s *mcpServer) dispatch(ctx context.Context, msg mcpMessage) (interface{}, error) {
+	if msg.JSONRPC != "2.0" || msg.Method == "" {
+		return nil, &mcpError{Code: mcpInvalidRequest, Message: "invalid JSON-RPC 2.0 request"}
+	}
+	switch msg.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		if err := decodeMCPParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		version := mcpProtocolVersions[0]
+		for _, supported := range mcpProtocolVersions {
+			if params.ProtocolVersion == supported {
+				version = supported
+			}
+		}
+		return gin.H{
+			"protocolVersion": version,
+			"capabilities":    gin.H{"tools": gin.H{}, "resources": gin.H{}},
+			"serverInfo":      gin.H{"name": "amazon-q-ollama", "version": "1.0.0"},
+		}, nil
+	case "ping":
+		return gin.H{}, nil
+	case "tools/list":
+		return gin.H{"tools": mcpTools}, nil
+	case "tools/call":
+		var params struct {
+			Name      string          `json:"name"`
+			Arguments json.RawMessage `json:"arguments"`
+		}
+		if err := decodeMCPParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.callTool(ctx, params.Name, params.Arguments)
+	case "resources/list":
+		resources, err := uploadedResources()
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"resources": resources}, nil
+	case "resources/read":
+		var params struct {
+			URI string `json:"uri"`
+		}
+		if err := decodeMCPParams(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		contents, err := readUploadedResource(params.URI)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"contents": []mcpResourceContents{contents}}, nil
+	default:
+		return nil, &mcpError{Code: mcpMethodNotFound, Message: fmt.Sprintf("method %q not found", msg.Method)}
+	}
+}
+
+func decodeMCPParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &mcpError{Code: mcpInvalidParams, Message: fmt.Sprintf("invalid params: %v", err)}
+	}
+	return nil
+}
+
+// callTool runs a tool through q. Failures are reported in the result, as
+// MCP asks, so the calling model can see them.
+func (s *mcpServer) callTool(ctx context.Context, name string, arguments json.RawMessage) (interface{}, error) {
+	var args struct {
+		Prompt       string `json:"prompt"`
+		Path         string `json:"path"`
+		Instructions string `json:"instructions"`
+	}
+	if err := decodeMCPParams(arguments, &args); err != nil {
+		return nil, err
+	}
+
+	var req QRequest
+	switch name {
+	case "ask_amazon_q":
+		if args.Prompt == "" {
+			return nil, &mcpError{Code: mcpInvalidParams, Message: "prompt is required"}
+		}
+		req.Prompt = args.Prompt
+	case "review_file":
+		if args.Path == "" {
+			return nil, &mcpError{Code: mcpInvalidParams, Message: "path is required"}
+		}
+		path, err := s.checkReviewFile(args.Path)
+		if err != nil {
+			return mcpToolError(err), nil
+		}
+		// The file is attached rather than inlined: a single argument is
+		// capped at 128 KiB on Linux
+		req.Prompt = reviewPrompt(filepath.Base(path), args.Instructions)
+		req.Files = []string{path}
+	default:
+		return nil, &mcpError{Code: mcpInvalidParams, Message: fmt.Sprintf("unknown tool %q", name)}
+	}
+
+	defer residentModel.Use(config.KeepAlive)()
+	response, err := backend.Generate(ctx, req)
+	if err != nil {
+		return mcpToolError(err), nil
+	}
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: response}}}, nil
+}
+
+func mcpToolError(err error) mcpToolResult {
+	return mcpToolResult{Content: []mcpContent{{Type: "text", Text: err.Error()}}, IsError: true}
+}
+
+// checkReviewFile returns the path of a text file review_file may attach,
+// limited to uploads unless the server runs locally
+func (s *mcpServer) checkReviewFile(pathOrURI string) (string, error) {
+	path := strings.TrimPrefix(pathOrURI, "file://")
+	if !s.localFiles && !isUploadedFile(path) {
+		return "", fmt.Errorf("%s is not an uploaded file; upload it with POST /upload first", pathOrURI)
+	}
+	data, err := readLimited(path)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("%s is not a text file", pathOrURI)
+	}
+	return path, nil
+}
+
+func reviewPrompt(name, instructions string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Review the attached file %s. Point out bugs, security risks and possible improvements.", name)
+	if instructions != "" {
+		b.WriteString("\n" + instructions)
+	}
+	return b.String()
+}
+
+func readLimited(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, mcpMaxFileBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > mcpMaxFileBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", path, mcpMaxFileBytes)
+	}
+	return data, nil
+}
+
+// isUploadedFile reports whether path is a file saved by /upload
+func isUploadedFile(path string) bool {
+	clean := filepath.Clean(path)
+	return filepath.Dir(clean) == filepath.Clean(os.TempDir()) && strings.HasPrefix(filepath.Base(clean), uploadPrefix)
+}
+
+// uploadedResources lists the files saved by /upload as MCP resources
+func uploadedResources() ([]mcpResource, error) {
+	paths, err := filepath.Glob(filepath.Join(os.TempDir(), uploadPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]mcpResource, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		resources = append(resources, mcpResource{
+			URI:      (&url.URL{Scheme: "file", Path: path}).String(),
+			Name:     uploadedName(path),
+			MimeType: mime.TypeByExtension(filepath.Ext(path)),
+			Size:     info.Size(),
+		})
+	}
+	return resources, nil
+}
+
+// uploadedName recovers the original file name from q_upload_<time>_<name>
+func uploadedName(path string) string {
+	name := strings.TrimPrefix(filepath.Base(path), uploadPrefix)
+	if _, original, ok := strings.Cut(name, "_"); ok {
+		return original
+	}
+	return name
+}
+
+func readUploadedResource(uri string) (mcpResourceContents, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "file" || !isUploadedFile(parsed.Path) {
+		return mcpResourceContents{}, &mcpError{Code: mcpInvalidParams, Message: fmt.Sprintf("unknown resource %q", uri)}
+	}
+	data, err := readLimited(parsed.Path)
+	if err != nil {
+		return mcpResourceContents{}, &mcpError{Code: mcpInvalidParams, Message: err.Error()}
+	}
+	contents := mcpResourceContents{URI: uri, MimeType: mime.TypeByExtension(filepath.Ext(parsed.Path))}
+	if utf8.Valid(data) {
+		contents.Text = string(data)
+	} else {
+		contents.Blob = base64.StdEncoding.EncodeToString(data)
+	}
+	return contents, nil
+}
+
+// serveMCPStdio runs the MCP stdio transport: newline-delimited JSON-RPC
+// messages on in and out. Requests are handled concurrently so a slow q
+// call does not hold up pings; notifications/cancelled stops a request.
+func serveMCPStdio(ctx context.Context, in io.Reader, out io.Writer) error {
+	server := &mcpServer{localFiles: true}
+	var writeMu, cancelMu sync.Mutex
+	var wg sync.WaitGroup
+	cancels := map[string]context.CancelFunc{}
+	encoder := json.NewEncoder(out)
+	write := func(response *mcpResponse) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := encoder.Encode(response); err != nil {
+			log.Printf("Failed to write MCP response: %v", err)
+		}
+	}
+
+	reader := bufio.NewReader(in)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var msg mcpMessage
+			if err := json.Unmarshal(line, &msg); err != nil {
+				write(&mcpResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &mcpError{Code: mcpParseError, Message: "parse error"}})
+			} else if msg.Method == "notifications/cancelled" {
+				var params struct {
+					RequestID json.RawMessage `json:"requestId"`
+				}
+				json.Unmarshal(msg.Params, &params)
+				cancelMu.Lock()
+				if cancel, ok := cancels[string(params.RequestID)]; ok {
+					cancel()
+				}
+				cancelMu.Unlock()
+			} else if msg.ID != nil {
+				requestCtx, cancel := context.WithCancel(ctx)
+				cancelMu.Lock()
+				cancels[string(msg.ID)] = cancel
+				cancelMu.Unlock()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					response := server.handle(requestCtx, msg)
+					// A cancelled request gets no response
+					cancelled := requestCtx.Err() != nil
+					cancelMu.Lock()
+					delete(cancels, string(msg.ID))
+					cancelMu.Unlock()
+					cancel()
+					if !cancelled {
+						write(response)
+					}
+				}()
+			}
+		}
+		if readErr != nil {
+			wg.Wait()
+			if readErr == io.EOF {
+				return nil
+			}
+			return readErr
+		}
+	}
+}
+
+// Handle POST /mcp, the MCP streamable HTTP transport. Each request is
+// answered with a single JSON response; the server never opens an SSE stream.
+func handleMCP(c *gin.Context) {
+	if !localOrigin(c.GetHeader("Origin")) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "origin not allowed"})
+		return
+	}
+
+	var msg mcpMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, mcpResponse{
+			JSONRPC: "2.0",
+			ID:      json.RawMessage("null"),
+			Error:   &mcpError{Code: mcpParseError, Message: "parse error"},
+		})
+		return
+	}
+
+	response := (&mcpServer{}).handle(c.Request.Context(), msg)
+	if response == nil {
+		c.Status(http.StatusAccepted)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Handle GET and DELETE /mcp; there is no server-initiated stream or session
+func handleMCPMethodNotAllowed(c *gin.Context) {
+	c.Header("Allow", "POST")
+	c.JSON(http.StatusMethodNotAllowed, gin.H{"error": "only POST is supported"})
+}
+
+// localOrigin guards against DNS rebinding: browsers may only reach /mcp
+// from a local page. Clients that send no Origin are not browsers.
+func localOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := parsed.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
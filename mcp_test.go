@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoPromptQ is a fake q that answers with the prompt it was given
+const echoPromptQ = "#!/bin/sh\nprintf '%s\\n' \"$3\"\n"
+
+// useUploadDir points the temp dir, where /upload saves files, at a fresh directory
+func useUploadDir(t *testing.T) string {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	return dir
+}
+
+// postMCP sends one JSON-RPC message to the HTTP transport
+func postMCP(t *testing.T, body string, origin string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/mcp", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	setupRouter().ServeHTTP(w, req)
+	return w
+}
+
+// mcpResult decodes a JSON-RPC response, failing on an error response
+func mcpResult(t *testing.T, data []byte) map[string]interface{} {
+	var response struct {
+		Result map[string]interface{} `json:"result"`
+		Error  *mcpError              `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(data, &response), string(data))
+	require.Nil(t, response.Error, string(data))
+	return response.Result
+}
+
+// toolText returns the text of a tools/call result and whether it is an error
+func toolText(t *testing.T, result map[string]interface{}) (string, bool) {
+	content := result["content"].([]interface{})
+	require.Len(t, content, 1)
+	isError, _ := result["isError"].(bool)
+	return content[0].(map[string]interface{})["text"].(string), isError
+}
+
+func TestMCPStdioSession(t *testing.T) {
+	useFakeQ(t, echoPromptQ)
+	useResidency(t, newResidency(nil))
+
+	in := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"ask_amazon_q","arguments":{"prompt":"What is Go?"}}}`,
+		`not json`,
+		`{"jsonrpc":"2.0","id":"four","method":"prompts/list"}`,
+	}, "\n")
+	var out bytes.Buffer
+	require.NoError(t, serveMCPStdio(context.Background(), strings.NewReader(in), &out))
+
+	responses := map[string]json.RawMessage{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var response struct {
+			ID json.RawMessage `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &response), line)
+		responses[string(response.ID)] = json.RawMessage(line)
+	}
+	require.Len(t, responses, 5, "every request and the parse error get one response; the notification none")
+
+	initialize := mcpResult(t, responses["1"])
+	assert.Equal(t, "2025-03-26", initialize["protocolVersion"])
+	assert.Contains(t, initialize["capabilities"], "tools")
+	assert.Contains(t, initialize["capabilities"], "resources")
+
+	tools := mcpResult(t, responses["2"])["tools"].([]interface{})
+	require.Len(t, tools, 2)
+	assert.Equal(t, "ask_amazon_q", tools[0].(map[string]interface{})["name"])
+	assert.Equal(t, "review_file", tools[1].(map[string]interface{})["name"])
+
+	text, isError := toolText(t, mcpResult(t, responses["3"]))
+	assert.False(t, isError)
+	assert.Equal(t, "What is Go?", text)
+
+	assert.Contains(t, string(responses["null"]), `"code":-32700`)
+	assert.Contains(t, string(responses[`"four"`]), `"code":-32601`)
+}
+
+func TestMCPReviewFile(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\necho \"$@\"\n")
+	useResidency(t, newResidency(nil))
+	local := filepath.Join(t.TempDir(), "main.go")
+	require.NoError(t, os.WriteFile(local, []byte("package main\n"), 0644))
+	uploaded := filepath.Join(useUploadDir(t), uploadPrefix+"1700000000_util.go")
+	require.NoError(t, os.WriteFile(uploaded, []byte("package util\n"), 0644))
+
+	call := func(server *mcpServer, path string) (string, bool) {
+		args, _ := json.Marshal(map[string]string{"path": path, "instructions": "Focus on naming."})
+		result, err := server.callTool(context.Background(), "review_file", args)
+		require.NoError(t, err)
+		data, _ := json.Marshal(result)
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &decoded))
+		return toolText(t, decoded)
+	}
+
+	text, isError := call(&mcpServer{localFiles: true}, local)
+	assert.False(t, isError)
+	assert.Contains(t, text, "Review the attached file main.go.")
+	assert.Contains(t, text, "Focus on naming.")
+	assert.Contains(t, text, "--file "+local)
+
+	// Larger than one argument may be, but attached as a file it fits
+	large := filepath.Join(t.TempDir(), "large.txt")
+	require.NoError(t, os.WriteFile(large, []byte(strings.Repeat("line of text\n", 20000)), 0644))
+	text, isError = call(&mcpServer{localFiles: true}, large)
+	assert.False(t, isError, text)
+	assert.Contains(t, text, "--file "+large)
+
+	text, isError = call(&mcpServer{}, local)
+	assert.True(t, isError, "over HTTP only uploaded files may be reviewed")
+	assert.Contains(t, text, "not an uploaded file")
+
+	text, isError = call(&mcpServer{}, "file://"+uploaded)
+	assert.False(t, isError)
+	assert.Contains(t, text, "--file "+uploaded)
+}
+
+func TestMCPToolsUseTheBackend(t *testing.T) {
+	fake := &fakeBackend{response: "Go is a language"}
+	useBackend(t, fake)
+	useResidency(t, newResidency(nil))
+
+	result, err := (&mcpServer{}).callTool(context.Background(), "ask_amazon_q", json.RawMessage(`{"prompt":"What is Go?"}`))
+	require.NoError(t, err)
+	data, _ := json.Marshal(result)
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	text, isError := toolText(t, decoded)
+	assert.False(t, isError)
+	assert.Equal(t, "Go is a language", text)
+	require.Len(t, fake.requests, 1)
+	assert.Equal(t, "What is Go?", fake.requests[0].Prompt)
+}
+
+func TestMCPHTTPResources(t *testing.T) {
+	dir := useUploadDir(t)
+	path := filepath.Join(dir, uploadPrefix+"1700000000_notes.txt")
+	require.NoError(t, os.WriteFile(path, []byte("remember the milk"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte("x"), 0644))
+
+	w := postMCP(t, `{"jsonrpc":"2.0","id":1,"method":"resources/list"}`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	resources := mcpResult(t, w.Body.Bytes())["resources"].([]interface{})
+	require.Len(t, resources, 1)
+	resource := resources[0].(map[string]interface{})
+	assert.Equal(t, "notes.txt", resource["name"])
+	assert.Equal(t, "file://"+path, resource["uri"])
+	assert.Equal(t, float64(17), resource["size"])
+
+	w = postMCP(t, `{"jsonrpc":"2.0","id":2,"method":"resources/read","params":{"uri":"file://`+path+`"}}`, "")
+	contents := mcpResult(t, w.Body.Bytes())["contents"].([]interface{})
+	assert.Equal(t, "remember the milk", contents[0].(map[string]interface{})["text"])
+
+	w = postMCP(t, `{"jsonrpc":"2.0","id":3,"method":"resources/read","params":{"uri":"file:///etc/passwd"}}`, "")
+	assert.Contains(t, w.Body.String(), `"code":-32602`)
+}
+
+func TestMCPHTTPTransport(t *testing.T) {
+	w := postMCP(t, `{"jsonrpc":"2.0","method":"notifications/initialized"}`, "")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	w = postMCP(t, `{"jsonrpc":"2.0","id":1,"method":"ping"}`, "http://localhost:6274")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{}}`, w.Body.String())
+
+	w = postMCP(t, `{"jsonrpc":"2.0","id":1,"method":"ping"}`, "https://evil.example")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postMCP(t, `{`, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":-32700`)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/mcp", nil)
+	setupRouter().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "POST", w.Header().Get("Allow"))
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Modelfile is a parsed Ollama Modelfile, or the same fields sent to
+// /api/create directly
+type Modelfile struct {
+	From     string
+	System   string
+	Template string
+	// Parameters holds PARAMETER values by name; stop may repeat
+	Parameters map[string]interface{}
+	Messages   []Message
+	License    []string
+}
+
+// messageRoles are the roles a MESSAGE instruction may use
+var messageRoles = map[string]bool{"system": true, "user": true, "assistant": true}
+
+// parseModelfile reads a Modelfile. FROM is required; ADAPTER is rejected
+// because q cannot load adapters. Values may be quoted with "..." or span
+// several lines inside """...""".
+func parseModelfile(text string) (*Modelfile, error) {
+	mf := &Modelfile{Parameters: map[string]interface{}{}}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyword, args, _ := strings.Cut(line, " ")
+		if tab := strings.IndexByte(keyword, '\t'); tab >= 0 {
+			keyword, args = keyword[:tab], keyword[tab+1:]+" "+args
+		}
+		args = strings.TrimSpace(args)
+
+		// A """ value runs on until the line that closes it
+		if open := strings.Index(args, `"""`); open >= 0 && !strings.Contains(args[open+3:], `"""`) {
+			var b strings.Builder
+			b.WriteString(args)
+			for {
+				i++
+				if i == len(lines) {
+					return nil, fmt.Errorf(`line %d: unterminated """`, lineNo)
+				}
+				b.WriteString("\n" + lines[i])
+				if strings.Contains(lines[i], `"""`) {
+					break
+				}
+			}
+			args = strings.TrimRight(b.String(), " \t")
+		}
+		if err := mf.apply(strings.ToUpper(keyword), args); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+	}
+	if mf.From == "" {
+		return nil, errors.New("no FROM line found in the Modelfile")
+	}
+	return mf, nil
+}
+
+// apply records one instruction of a Modelfile
+func (mf *Modelfile) apply(instruction, args string) error {
+	if args == "" {
+		return fmt.Errorf("%s needs an argument", instruction)
+	}
+	switch instruction {
+	case "FROM":
+		if mf.From != "" {
+			return errors.New("only one FROM is supported")
+		}
+		mf.From = unquoteModelfile(args)
+	case "SYSTEM":
+		mf.System = unquoteModelfile(args)
+	case "TEMPLATE":
+		mf.Template = unquoteModelfile(args)
+	case "LICENSE":
+		mf.License = append(mf.License, unquoteModelfile(args))
+	case "PARAMETER":
+		name, value, ok := cutField(args)
+		if !ok {
+			return fmt.Errorf("PARAMETER %s needs a value", name)
+		}
+		mf.setParameter(strings.ToLower(name), unquoteModelfile(value))
+	case "MESSAGE":
+		role, content, ok := cutField(args)
+		role = strings.ToLower(role)
+		if !messageRoles[role] {
+			return fmt.Errorf("MESSAGE role must be system, user or assistant, not %q", role)
+		}
+		if !ok {
+			return errors.New("MESSAGE needs content")
+		}
+		mf.Messages = append(mf.Messages, Message{Role: role, Content: unquoteModelfile(content)})
+	case "ADAPTER":
+		return errors.New("ADAPTER is not supported: Amazon Q cannot load adapters")
+	default:
+		return fmt.Errorf("unknown instruction %q", instruction)
+	}
+	return nil
+}
+
+// setParameter stores a PARAMETER value as the JSON type options expect
+func (mf *Modelfile) setParameter(name, value string) {
+	if name == "stop" {
+		stop, _ := mf.Parameters["stop"].([]string)
+		mf.Parameters["stop"] = append(stop, value)
+		return
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		mf.Parameters[name] = n
+		return
+	}
+	mf.Parameters[name] = value
+}
+
+// cutField splits the first whitespace-separated word from args
+func cutField(args string) (field, rest string, ok bool) {
+	i := strings.IndexAny(args, " \t")
+	if i < 0 {
+		return args, "", false
+	}
+	rest = strings.TrimSpace(args[i:])
+	return args[:i], rest, rest != ""
+}
+
+// unquoteModelfile strips """ or " quoting from a value
+func unquoteModelfile(value string) string {
+	if len(value) >= 6 && strings.HasPrefix(value, `"""`) && strings.HasSuffix(value, `"""`) {
+		return value[3 : len(value)-3]
+	}
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// virtualModel turns the Modelfile into the registry entry for name.
+// Parameters are checked the way request options are.
+func (mf *Modelfile) virtualModel(name string) (VirtualModel, error) {
+	model := VirtualModel{
+		Name:     name,
+		From:     mf.From,
+		System:   mf.System,
+		Template: mf.Template,
+		Messages: mf.Messages,
+		License:  strings.Join(mf.License, "\n\n"),
+	}
+	if model.Template != "" {
+		if _, err := renderTemplate(model.Template, templateData{}); err != nil {
+			return VirtualModel{}, err
+		}
+	}
+	if len(mf.Parameters) > 0 {
+		data, err := json.Marshal(mf.Parameters)
+		if err != nil {
+			return VirtualModel{}, err
+		}
+		model.Options = &Options{}
+		if err := json.Unmarshal(data, model.Options); err != nil {
+			return VirtualModel{}, err
+		}
+	}
+	return model, nil
+}
+
+// modelLicense is a license sent to /api/create as one string or a list
+type modelLicense []string
+
+func (l *modelLicense) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = modelLicense{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("license must be a string or a list of strings")
+	}
+	*l = list
+	return nil
+}
+
+// modelfile returns the model definition of a create request: the parsed
+// Modelfile when one is sent, or else the request's own fields
+func (req CreateRequest) modelfile() (*Modelfile, error) {
+	if len(req.Adapters) > 0 {
+		return nil, errors.New("adapters are not supported: Amazon Q cannot load adapters")
+	}
+	if len(req.Files) > 0 {
+		return nil, errors.New("model files are not supported: FROM must name amazon-q or another virtual model")
+	}
+	if req.Modelfile != "" {
+		return parseModelfile(req.Modelfile)
+	}
+	if req.From == "" {
+		return nil, errors.New("neither 'from' or 'modelfile' specified")
+	}
+	for _, msg := range req.Messages {
+		if !messageRoles[msg.Role] {
+			return nil, fmt.Errorf("message role must be system, user or assistant, not %q", msg.Role)
+		}
+	}
+	return &Modelfile{
+		From:       req.From,
+		System:     req.System,
+		Template:   req.Template,
+		Parameters: req.Parameters,
+		Messages:   req.Messages,
+		License:    req.License,
+	}, nil
+}
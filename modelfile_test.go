@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reviewerModelfile = `# A code reviewer
+FROM amazon-q
+system """
+You review code.
+Be brief.
+"""
+PARAMETER num_predict 200
+PARAMETER temperature 0.2
+PARAMETER stop "END"
+PARAMETER stop <|end|>
+MESSAGE user Is this safe?
+MESSAGE assistant "No: the input is not escaped."
+LICENSE MIT
+`
+
+func TestParseModelfile(t *testing.T) {
+	mf, err := parseModelfile(reviewerModelfile)
+	require.NoError(t, err)
+	assert.Equal(t, "amazon-q", mf.From)
+	assert.Equal(t, "\nYou review code.\nBe brief.\n", mf.System)
+	assert.Equal(t, map[string]interface{}{
+		"num_predict": float64(200),
+		"temperature": 0.2,
+		"stop":        []string{"END", "<|end|>"},
+	}, mf.Parameters)
+	assert.Equal(t, []Message{
+		{Role: "user", Content: "Is this safe?"},
+		{Role: "assistant", Content: "No: the input is not escaped."},
+	}, mf.Messages)
+	assert.Equal(t, []string{"MIT"}, mf.License)
+
+	model, err := mf.virtualModel("q-reviewer")
+	require.NoError(t, err)
+	assert.Equal(t, 200, *model.Options.NumPredict)
+	assert.Equal(t, []string{"END", "<|end|>"}, model.Options.Stop)
+	assert.Equal(t, "MIT", model.License)
+
+	mf, err = parseModelfile("FROM q-base\nTEMPLATE \"\"\"{{ .System }} | {{ .Prompt }}\"\"\"\n")
+	require.NoError(t, err)
+	assert.Equal(t, "{{ .System }} | {{ .Prompt }}", mf.Template)
+}
+
+func TestParseModelfileErrors(t *testing.T) {
+	cases := map[string]string{
+		"SYSTEM hi":                          "no FROM line found in the Modelfile",
+		"FROM amazon-q\nADAPTER ./lora.gguf": "line 2: ADAPTER is not supported: Amazon Q cannot load adapters",
+		"FROM amazon-q\nFROM amazon-q":       "line 2: only one FROM is supported",
+		"FROM amazon-q\nQUANTIZE q4":         `line 2: unknown instruction "QUANTIZE"`,
+		"FROM amazon-q\nSYSTEM \"\"\"open":   `line 2: unterminated """`,
+		"FROM amazon-q\nPARAMETER seed":      "line 2: PARAMETER seed needs a value",
+		"FROM amazon-q\nMESSAGE tool result": `line 2: MESSAGE role must be system, user or assistant, not "tool"`,
+		"FROM amazon-q\nMESSAGE user":        "line 2: MESSAGE needs content",
+		"FROM":                               "line 1: FROM needs an argument",
+	}
+	for text, message := range cases {
+		_, err := parseModelfile(text)
+		assert.EqualError(t, err, message, text)
+	}
+
+	mf, err := parseModelfile("FROM amazon-q\nPARAMETER num_predict lots")
+	require.NoError(t, err)
+	_, err = mf.virtualModel("q")
+	assert.EqualError(t, err, `option "num_predict" must be an integer`)
+
+	mf, err = parseModelfile("FROM amazon-q\nTEMPLATE {{ .Prompt")
+	require.NoError(t, err)
+	_, err = mf.virtualModel("q")
+	assert.ErrorContains(t, err, "invalid template")
+}
+
+func TestCreateFromModelfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.json")
+	useRegistry(t, newModelRegistry(path))
+	useResidency(t, newResidency(nil))
+
+	w := postJSON(t, "/api/create", CreateRequest{Model: "q-reviewer", Modelfile: reviewerModelfile})
+	require.Equal(t, http.StatusOK, w.Code)
+	var statuses []interface{}
+	for _, frame := range readNDJSON(t, w.Body.String()) {
+		statuses = append(statuses, frame["status"])
+	}
+	assert.Equal(t, []interface{}{"parsing modelfile", "using base model amazon-q:latest", "writing manifest", "success"}, statuses)
+
+	m, ok := loadModelRegistry(path).Get("q-reviewer")
+	require.True(t, ok, "created models are persisted")
+	assert.Equal(t, "\nYou review code.\nBe brief.\n", m.System)
+
+	w = postJSON(t, "/api/show", ShowRequest{Name: "q-reviewer"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var show ShowResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &show))
+	assert.Equal(t, "MIT", show.License)
+	assert.Len(t, show.Messages, 2)
+	assert.Contains(t, show.Modelfile, "MESSAGE user \"\"\"Is this safe?\"\"\"\n")
+
+	// The Modelfile shown can be fed back to create
+	reparsed, err := parseModelfile(show.Modelfile)
+	require.NoError(t, err)
+	assert.Equal(t, m.Messages, reparsed.Messages)
+
+	fake := &fakeBackend{response: "Fine"}
+	useBackend(t, fake)
+	w = postJSON(t, "/api/chat", ChatRequest{Model: "q-reviewer", Messages: []Message{{Role: "user", Content: "And this?"}}, Stream: boolPtr(false)})
+	require.Equal(t, http.StatusOK, w.Code)
+	w = postJSON(t, "/api/generate", GenerateRequest{Model: "q-reviewer", Prompt: "And this?", Stream: boolPtr(false)})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, fake.requests, 2)
+	for _, req := range fake.requests {
+		assert.Contains(t, req.Prompt, "Is this safe?")
+		assert.Contains(t, req.Prompt, "No: the input is not escaped.")
+		assert.Contains(t, req.Prompt, "And this?")
+	}
+}
+
+func TestCreateFromFields(t *testing.T) {
+	r := useRegistry(t, newModelRegistry(""))
+	require.NoError(t, r.Put(VirtualModel{Name: "q-base", From: "amazon-q", QFlags: []string{"--agent", "base"}}))
+
+	w := postJSON(t, "/api/create", map[string]interface{}{
+		"model":      "q-child",
+		"from":       "q-base",
+		"system":     "You are terse.",
+		"parameters": map[string]interface{}{"stop": []string{"END"}},
+		"license":    []string{"MIT", "Apache-2.0"},
+		"stream":     false,
+	})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status":"success"}`, w.Body.String())
+
+	model, err := r.Resolve("q-child")
+	require.NoError(t, err)
+	assert.Equal(t, "You are terse.", model.System)
+	assert.Equal(t, []string{"END"}, model.Options.Stop)
+	assert.Equal(t, []string{"--agent", "base"}, model.QFlags)
+	assert.Equal(t, "MIT\n\nApache-2.0", model.License)
+}
+
+func TestCreateRejections(t *testing.T) {
+	r := useRegistry(t, newModelRegistry(""))
+	require.NoError(t, r.Put(VirtualModel{Name: "q-base", From: "amazon-q"}))
+
+	cases := []struct {
+		name   string
+		body   map[string]interface{}
+		status int
+		error  string
+	}{
+		{"no name", map[string]interface{}{"modelfile": "FROM amazon-q"}, http.StatusBadRequest, "model is required"},
+		{"no definition", map[string]interface{}{"model": "q"}, http.StatusBadRequest, "neither 'from' or 'modelfile' specified"},
+		{"adapter", map[string]interface{}{"model": "q", "modelfile": "FROM amazon-q\nADAPTER ./x.gguf"}, http.StatusBadRequest, "ADAPTER is not supported"},
+		{"weights", map[string]interface{}{"model": "q", "files": map[string]string{"model.gguf": "sha256:abc"}}, http.StatusBadRequest, "model files are not supported"},
+		{"unknown parent", map[string]interface{}{"model": "q", "modelfile": "FROM llama3"}, http.StatusNotFound, `model \"llama3:latest\" not found`},
+		{"base model", map[string]interface{}{"model": "amazon-q", "from": "amazon-q"}, http.StatusBadRequest, "cannot be replaced"},
+		{"cycle", map[string]interface{}{"model": "q-base", "from": "q-base"}, http.StatusBadRequest, "cannot be built on itself"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := postJSON(t, "/api/create", tc.body)
+			assert.Equal(t, tc.status, w.Code)
+			assert.Contains(t, w.Body.String(), tc.error)
+		})
+	}
+	assert.Len(t, r.List(), 2, "rejected models are not stored")
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// baseModel is the model backed directly by Amazon Q. Every virtual model
+// is built on it.
+const baseModel = "amazon-q:latest"
+
+// maxModelDepth bounds how many FROM links are followed when resolving a model
+const maxModelDepth = 16
+
+// VirtualModel is a named persona served on top of Amazon Q, such as
+// q-reviewer:latest. Unset fields are inherited from the model it is built
+// FROM.
+type VirtualModel struct {
+	Name     string   `json:"name"`
+	From     string   `json:"from"`
+	System   string   `json:"system,omitempty"`
+	Template string   `json:"template,omitempty"`
+	Options  *Options `json:"options,omitempty"`
+	// Messages open every conversation with this model
+	Messages []Message `json:"messages,omitempty"`
+	License  string    `json:"license,omitempty"`
+	// QFlags are passed to every q chat invocation for this model
+	QFlags     []string  `json:"q_flags,omitempty"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// resolvedModel is a model with the settings of the models it is built on
+// folded in
+type resolvedModel struct {
+	Name     string
+	System   string
+	Template string
+	Options  *Options
+	Messages []Message
+	License  string
+	QFlags   []string
+}
+
+// ModelNotFoundError reports a model name missing from the registry
+type ModelNotFoundError struct {
+	Name string
+}
+
+func (e *ModelNotFoundError) Error() string {
+	return fmt.Sprintf("model %q not found", e.Name)
+}
+
+// modelRegistry holds the virtual models and persists them as JSON. The
+// base model is always present and is never written to the file.
+type modelRegistry struct {
+	mu     sync.RWMutex
+	path   string
+	models map[string]*VirtualModel
+	// lastUsed records when each model last served a request, for /api/ps
+	lastUsed map[string]time.Time
+}
+
+// registry is the model registry used by all handlers
+var registry = loadModelRegistry(config.ModelsFile)
+
+// newModelRegistry returns a registry persisted at path; an empty path
+// keeps it in memory only
+func newModelRegistry(path string) *modelRegistry {
+	return &modelRegistry{path: path, models: map[string]*VirtualModel{}, lastUsed: map[string]time.Time{}}
+}
+
+// loadModelRegistry reads the registry file at path. A missing file is an
+// empty registry; an unreadable one is logged and ignored.
+func loadModelRegistry(path string) *modelRegistry {
+	r := newModelRegistry(path)
+	if path == "" {
+		return r
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r
+	}
+	var models []*VirtualModel
+	if err == nil {
+		err = json.Unmarshal(data, &models)
+	}
+	if err != nil {
+		log.Printf("Ignoring model registry %s: %v", path, err)
+		return r
+	}
+	for _, m := range models {
+		m.Name = normalizeModelName(m.Name)
+		m.From = normalizeModelName(m.From)
+		if m.Name == baseModel {
+			continue
+		}
+		r.models[m.Name] = m
+	}
+	return r
+}
+
+// defaultModelsFile is where the registry is kept when Q_MODELS_FILE is unset
+func defaultModelsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".amazon-q-ollama", "models.json")
+}
+
+// normalizeModelName adds the :latest tag to an untagged name. An empty
+// name stands for the base model.
+func normalizeModelName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return baseModel
+	}
+	if !strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		name += ":latest"
+	}
+	return name
+}
+
+// Resolve looks name up and folds in the settings it inherits. Settings on
+// a model override those of the model it is built on; q flags accumulate.
+func (r *modelRegistry) Resolve(name string) (resolvedModel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	name = normalizeModelName(name)
+	resolved := resolvedModel{Name: name}
+	var chain []*VirtualModel
+	for current := name; current != baseModel; {
+		m, ok := r.models[current]
+		if !ok {
+			return resolvedModel{}, &ModelNotFoundError{Name: current}
+		}
+		if len(chain) == maxModelDepth {
+			return resolvedModel{}, fmt.Errorf("model %q is built on too many models", name)
+		}
+		chain = append(chain, m)
+		current = m.From
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		m := chain[i]
+		if m.System != "" {
+			resolved.System = m.System
+		}
+		if m.Template != "" {
+			resolved.Template = m.Template
+		}
+		if len(m.Messages) > 0 {
+			resolved.Messages = m.Messages
+		}
+		if m.License != "" {
+			resolved.License = m.License
+		}
+		resolved.Options = mergeOptions(resolved.Options, m.Options)
+		resolved.QFlags = append(resolved.QFlags, m.QFlags...)
+	}
+	return resolved, nil
+}
+
+// Put adds or replaces a virtual model and saves the registry. The model it
+// is built on must exist.
+func (r *modelRegistry) Put(m VirtualModel) error {
+	m.Name = normalizeModelName(m.Name)
+	m.From = normalizeModelName(m.From)
+	if m.ModifiedAt.IsZero() {
+		m.ModifiedAt = time.Now()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkLocked(m); err != nil {
+		return err
+	}
+	previous := r.models[m.Name]
+	r.models[m.Name] = &m
+	if err := r.saveLocked(); err != nil {
+		r.restoreLocked(m.Name, previous)
+		return err
+	}
+	return nil
+}
+
+// Check reports whether Put would accept m, without saving it
+func (r *modelRegistry) Check(m VirtualModel) error {
+	m.Name = normalizeModelName(m.Name)
+	m.From = normalizeModelName(m.From)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.checkLocked(m)
+}
+
+// checkLocked validates a normalized model definition; the caller holds r.mu
+func (r *modelRegistry) checkLocked(m VirtualModel) error {
+	if m.Name == baseModel {
+		return fmt.Errorf("model %q is built in and cannot be replaced", baseModel)
+	}
+	if m.From != baseModel && r.models[m.From] == nil {
+		return &ModelNotFoundError{Name: m.From}
+	}
+	for current, depth := m.From, 0; current != baseModel && depth < maxModelDepth; depth++ {
+		if current == m.Name {
+			return fmt.Errorf("model %q cannot be built on itself", m.Name)
+		}
+		parent, ok := r.models[current]
+		if !ok {
+			break
+		}
+		current = parent.From
+	}
+	return nil
+}
+
+func (r *modelRegistry) restoreLocked(name string, previous *VirtualModel) {
+	if previous == nil {
+		delete(r.models, name)
+	} else {
+		r.models[name] = previous
+	}
+}
+
+// saveLocked writes the registry file atomically; the caller holds r.mu
+func (r *modelRegistry) saveLocked() error {
+	if r.path == "" {
+		return nil
+	}
+	models := r.sortedLocked()
+	data, err := json.MarshalIndent(models, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to save model registry: %v", err)
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to save model registry: %v", err)
+	}
+	if err := os.Rename(tmp, r.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to save model registry: %v", err)
+	}
+	return nil
+}
+
+// sortedLocked returns the virtual models by name; the caller holds r.mu
+func (r *modelRegistry) sortedLocked() []*VirtualModel {
+	models := make([]*VirtualModel, 0, len(r.models))
+	for _, m := range r.models {
+		models = append(models, m)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Name < models[j].Name })
+	return models
+}
+
+// Get returns the stored definition of a virtual model
+func (r *modelRegistry) Get(name string) (VirtualModel, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	m, ok := r.models[normalizeModelName(name)]
+	if !ok {
+		return VirtualModel{}, false
+	}
+	return *m, true
+}
+
+// List returns the base model followed by the virtual models, by name
+func (r *modelRegistry) List() []ModelInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	infos := []ModelInfo{baseModelInfo()}
+	for _, m := range r.sortedLocked() {
+		infos = append(infos, m.info())
+	}
+	return infos
+}
+
+// markUsed records that name is serving a request. Names the registry does
+// not know are served by the base model.
+func (r *modelRegistry) markUsed(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name = normalizeModelName(name)
+	if r.models[name] == nil {
+		name = baseModel
+	}
+	r.lastUsed[name] = time.Now()
+}
+
+// usedSince lists the existing models used at or after since, by name
+func (r *modelRegistry) usedSince(since time.Time) []ModelInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var infos []ModelInfo
+	for name, used := range r.lastUsed {
+		if used.Before(since) {
+			continue
+		}
+		if name == baseModel {
+			infos = append(infos, baseModelInfo())
+		} else if m, ok := r.models[name]; ok {
+			infos = append(infos, m.info())
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// resolveModel resolves name, falling back to the base model for names the
+// registry does not know. Handlers that must reject unknown models check
+// registry.Resolve first.
+func resolveModel(name string) resolvedModel {
+	model, err := registry.Resolve(name)
+	if err != nil {
+		return resolvedModel{Name: baseModel}
+	}
+	return model
+}
+
+// responseModel is the model named in responses: the one the request asked
+// for, or amazon-q when it named none
+func responseModel(name string) string {
+	if strings.TrimSpace(name) == "" {
+		return "amazon-q"
+	}
+	return name
+}
+
+// withSystem puts a model's system prompt ahead of a conversation that does
+// not bring its own
+func withSystem(messages []Message, system string) []Message {
+	if system == "" {
+		return messages
+	}
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			return messages
+		}
+	}
+	return append([]Message{{Role: "system", Content: system}}, messages...)
+}
+
+// withModel opens a conversation with a model's messages and system prompt
+func withModel(messages []Message, model resolvedModel) []Message {
+	if len(model.Messages) > 0 {
+		messages = append(append([]Message(nil), model.Messages...), messages...)
+	}
+	return withSystem(messages, model.System)
+}
+
+// messageTurns pairs a model's user and assistant messages into the turns
+// a generate request starts from. System messages are left to the template.
+func messageTurns(messages []Message) []conversationTurn {
+	var turns []conversationTurn
+	for _, msg := range messages {
+		switch msg.Role {
+		case "user":
+			turns = append(turns, conversationTurn{Prompt: msg.Content})
+		case "assistant":
+			if len(turns) == 0 || turns[len(turns)-1].Response != "" {
+				turns = append(turns, conversationTurn{})
+			}
+			turns[len(turns)-1].Response = msg.Content
+		}
+	}
+	return turns
+}
+
+// useModel marks name as serving a request and keeps q loaded, as
+// residentModel.Use does
+func useModel(name string, keepAlive time.Duration) (done func()) {
+	done = residentModel.Use(keepAlive)
+	registry.markUsed(name)
+	return done
+}
+
+// modelDetails describes every model the proxy serves; parent is the model
+// a virtual model is built on
+func modelDetails(parent string) ModelDetails {
+	return ModelDetails{
+		ParentModel:       parent,
+		Format:            "amazon-q-service",
+		Family:            "amazon-q",
+		ParameterSize:     "unknown",
+		QuantizationLevel: "unknown",
+	}
+}
+
+func baseModelInfo() ModelInfo {
+	return ModelInfo{
+		Name:       baseModel,
+		Model:      "amazon-q",
+		ModifiedAt: time.Now(),
+		Size:       0, // Amazon Q is a service, not a local model
+		Digest:     "sha256:amazon-q-service",
+		Details:    modelDetails(""),
+	}
+}
+
+func (m *VirtualModel) info() ModelInfo {
+	return ModelInfo{
+		Name:       m.Name,
+		Model:      m.Name,
+		ModifiedAt: m.ModifiedAt,
+		Size:       0,
+		Digest:     m.digest(),
+		Details:    modelDetails(m.From),
+	}
+}
+
+// digest identifies a model definition, so it changes whenever the model does
+func (m *VirtualModel) digest() string {
+	data, _ := json.Marshal(struct {
+		From     string
+		System   string
+		Template string
+		Options  *Options
+		QFlags   []string
+	}{m.From, m.System, m.Template, m.Options, m.QFlags})
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// showModel describes a model for /api/show
+func showModel(name string) (ShowResponse, error) {
+	model, err := registry.Resolve(name)
+	if err != nil {
+		return ShowResponse{}, err
+	}
+	template := model.Template
+	if template == "" {
+		template = defaultTemplate
+	}
+	m, ok := registry.Get(model.Name)
+	if !ok {
+		return ShowResponse{
+			Modelfile: "# Amazon Q Service Model\nFROM amazon-q-service",
+			Template:  template,
+			Details:   modelDetails(""),
+		}, nil
+	}
+	return ShowResponse{
+		Modelfile:  m.modelfile(),
+		Parameters: strings.Join(modelfileParameters(model.Options), "\n"),
+		Template:   template,
+		System:     model.System,
+		License:    model.License,
+		Messages:   model.Messages,
+		Details:    modelDetails(m.From),
+	}, nil
+}
+
+// modelfile renders the definition of a virtual model as a Modelfile. q
+// flags have no Modelfile instruction and are shown as a comment.
+func (m *VirtualModel) modelfile() string {
+	lines := []string{"# Modelfile generated by amazon-q-ollama", "FROM " + m.From}
+	if m.Template != "" {
+		lines = append(lines, `TEMPLATE """`+m.Template+`"""`)
+	}
+	if m.System != "" {
+		lines = append(lines, `SYSTEM """`+m.System+`"""`)
+	}
+	for _, parameter := range modelfileParameters(m.Options) {
+		lines = append(lines, "PARAMETER "+parameter)
+	}
+	for _, msg := range m.Messages {
+		lines = append(lines, "MESSAGE "+msg.Role+` """`+msg.Content+`"""`)
+	}
+	if m.License != "" {
+		lines = append(lines, `LICENSE """`+m.License+`"""`)
+	}
+	if len(m.QFlags) > 0 {
+		lines = append(lines, "# q flags: "+strings.Join(m.QFlags, " "))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// modelfileParameters renders options as Modelfile parameters, one
+// "name value" pair per line and one line per stop sequence
+func modelfileParameters(options *Options) []string {
+	if options == nil {
+		return nil
+	}
+	var parameters []string
+	for _, p := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"num_predict", options.NumPredict},
+		{"temperature", options.Temperature},
+		{"top_k", options.TopK},
+		{"top_p", options.TopP},
+		{"seed", options.Seed},
+		{"frequency_penalty", options.FrequencyPenalty},
+		{"presence_penalty", options.PresencePenalty},
+		{"repeat_penalty", options.RepeatPenalty},
+	} {
+		switch v := p.value.(type) {
+		case *int:
+			if v != nil {
+				parameters = append(parameters, fmt.Sprintf("%s %d", p.name, *v))
+			}
+		case *float64:
+			if v != nil {
+				parameters = append(parameters, fmt.Sprintf("%s %v", p.name, *v))
+			}
+		}
+	}
+	for _, stop := range options.Stop {
+		parameters = append(parameters, fmt.Sprintf("stop %q", stop))
+	}
+	return parameters
+}
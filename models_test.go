@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain keeps tests away from the registry file in the user's home
+func TestMain(m *testing.M) {
+	registry = newModelRegistry("")
+	os.Exit(m.Run())
+}
+
+// useRegistry swaps the package registry for the duration of a test
+func useRegistry(t *testing.T, r *modelRegistry) *modelRegistry {
+	previous := registry
+	registry = r
+	t.Cleanup(func() { registry = previous })
+	return r
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestNormalizeModelName(t *testing.T) {
+	cases := map[string]string{
+		"":                   baseModel,
+		"amazon-q":           baseModel,
+		"q-reviewer":         "q-reviewer:latest",
+		"q-reviewer:v2":      "q-reviewer:v2",
+		"team/q-terraform":   "team/q-terraform:latest",
+		"host:5000/q-review": "host:5000/q-review:latest",
+	}
+	for name, want := range cases {
+		assert.Equal(t, want, normalizeModelName(name), name)
+	}
+}
+
+func TestRegistryResolve(t *testing.T) {
+	r := useRegistry(t, newModelRegistry(""))
+	require.NoError(t, r.Put(VirtualModel{
+		Name:     "q-reviewer",
+		From:     "amazon-q",
+		System:   "You review code.",
+		Template: "{{ .System }} | {{ .Prompt }}",
+		Options:  &Options{NumPredict: intPtr(100), Stop: []string{"END"}},
+		QFlags:   []string{"--agent", "reviewer"},
+	}))
+	require.NoError(t, r.Put(VirtualModel{
+		Name:    "q-strict-reviewer:latest",
+		From:    "q-reviewer",
+		System:  "You review code strictly.",
+		Options: &Options{NumPredict: intPtr(20)},
+		QFlags:  []string{"--trust-tools=fs_read"},
+	}))
+
+	model, err := r.Resolve("q-strict-reviewer")
+	require.NoError(t, err)
+	assert.Equal(t, "q-strict-reviewer:latest", model.Name)
+	assert.Equal(t, "You review code strictly.", model.System)
+	assert.Equal(t, "{{ .System }} | {{ .Prompt }}", model.Template, "unset fields are inherited")
+	assert.Equal(t, 20, *model.Options.NumPredict)
+	assert.Equal(t, []string{"END"}, model.Options.Stop)
+	assert.Equal(t, []string{"--agent", "reviewer", "--trust-tools=fs_read"}, model.QFlags)
+
+	model, err = r.Resolve("amazon-q:latest")
+	require.NoError(t, err)
+	assert.Equal(t, resolvedModel{Name: baseModel}, model)
+
+	_, err = r.Resolve("llama3")
+	var notFound *ModelNotFoundError
+	require.ErrorAs(t, err, &notFound)
+	assert.Equal(t, `model "llama3:latest" not found`, err.Error())
+
+	assert.Error(t, r.Put(VirtualModel{Name: "orphan", From: "missing"}))
+	assert.Error(t, r.Put(VirtualModel{Name: "amazon-q", From: "amazon-q"}))
+
+	// A cycle can only come from a hand-edited registry file
+	r.models["a:latest"] = &VirtualModel{Name: "a:latest", From: "b:latest"}
+	r.models["b:latest"] = &VirtualModel{Name: "b:latest", From: "a:latest"}
+	_, err = r.Resolve("a")
+	assert.ErrorContains(t, err, "too many models")
+}
+
+func TestRegistryPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry", "models.json")
+	r := newModelRegistry(path)
+	require.NoError(t, r.Put(VirtualModel{Name: "q-terraform", From: "amazon-q", System: "You write Terraform.", QFlags: []string{"--agent", "terraform"}}))
+
+	reloaded := loadModelRegistry(path)
+	m, ok := reloaded.Get("q-terraform")
+	require.True(t, ok)
+	assert.Equal(t, "You write Terraform.", m.System)
+	assert.Equal(t, []string{"--agent", "terraform"}, m.QFlags)
+	assert.Equal(t, baseModel, m.From)
+
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0644))
+	assert.Len(t, loadModelRegistry(path).List(), 1, "an unreadable file leaves only the base model")
+	assert.Len(t, loadModelRegistry(filepath.Join(t.TempDir(), "missing.json")).List(), 1)
+}
+
+func TestVirtualModelEndpoints(t *testing.T) {
+	r := useRegistry(t, newModelRegistry(""))
+	useResidency(t, newResidency(nil))
+	require.NoError(t, r.Put(VirtualModel{
+		Name:    "q-reviewer",
+		From:    "amazon-q",
+		System:  "You review code.",
+		Options: &Options{NumPredict: intPtr(100)},
+		QFlags:  []string{"--agent", "reviewer"},
+	}))
+
+	var tags TagsResponse
+	w := getJSON(t, "/api/tags")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &tags))
+	require.Len(t, tags.Models, 2)
+	assert.Equal(t, baseModel, tags.Models[0].Name)
+	assert.Equal(t, "q-reviewer:latest", tags.Models[1].Name)
+	assert.Equal(t, baseModel, tags.Models[1].Details.ParentModel)
+
+	w = postJSON(t, "/api/show", ShowRequest{Name: "q-reviewer"})
+	require.Equal(t, http.StatusOK, w.Code)
+	var show ShowResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &show))
+	assert.Equal(t, "You review code.", show.System)
+	assert.Equal(t, "num_predict 100", show.Parameters)
+	assert.Equal(t, defaultTemplate, show.Template)
+	assert.Contains(t, show.Modelfile, "FROM amazon-q:latest\n")
+	assert.Contains(t, show.Modelfile, "# q flags: --agent reviewer")
+
+	w = postJSON(t, "/api/show", ShowRequest{Name: "llama3"})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"model_not_found"`)
+
+	fake := &fakeBackend{response: "Looks good"}
+	useBackend(t, fake)
+	w = postJSON(t, "/api/generate", GenerateRequest{Model: "q-reviewer", Prompt: "Review this", Stream: boolPtr(false)})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"model":"q-reviewer"`, "responses name the requested model")
+	w = postJSON(t, "/api/chat", ChatRequest{Model: "q-reviewer", Messages: []Message{{Role: "user", Content: "Review this"}}})
+	require.Equal(t, http.StatusOK, w.Code)
+	for _, frame := range readNDJSON(t, w.Body.String()) {
+		assert.Equal(t, "q-reviewer", frame["model"])
+	}
+	require.Len(t, fake.requests, 2)
+	for _, req := range fake.requests {
+		assert.Contains(t, req.Prompt, "You review code.")
+		assert.Equal(t, []string{"--agent", "reviewer"}, req.Flags)
+		assert.Equal(t, 100, *req.Options.NumPredict)
+	}
+
+	var ps PsResponse
+	require.NoError(t, json.Unmarshal(getJSON(t, "/api/ps").Body.Bytes(), &ps))
+	require.Len(t, ps.Models, 1)
+	assert.Equal(t, "q-reviewer:latest", ps.Models[0].Name)
+
+	for _, path := range []string{"/api/generate", "/api/chat"} {
+		w = postJSON(t, path, map[string]interface{}{"model": "llama3", "prompt": "hi", "messages": []Message{{Role: "user", Content: "hi"}}})
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
+
+func TestVirtualModelsOnCompatibleEndpoints(t *testing.T) {
+	r := useRegistry(t, newModelRegistry(""))
+	useResidency(t, newResidency(nil))
+	require.NoError(t, r.Put(VirtualModel{
+		Name:    "q-reviewer",
+		From:    "amazon-q",
+		System:  "You review code.",
+		Options: &Options{NumPredict: intPtr(100)},
+		QFlags:  []string{"--agent", "reviewer"},
+	}))
+	fake := &fakeBackend{response: "Looks good"}
+	useBackend(t, fake)
+
+	messages := []map[string]string{{"role": "user", "content": "Review this"}}
+	requests := map[string]map[string]interface{}{
+		"/v1/chat/completions": {"messages": messages},
+		"/v1/completions":      {"prompt": "Review this"},
+		"/v1/messages":         {"messages": messages, "max_tokens": 100},
+	}
+	for path, body := range requests {
+		body["model"] = "q-reviewer"
+		w := postJSON(t, path, body)
+		require.Equal(t, http.StatusOK, w.Code, path)
+	}
+	require.Len(t, fake.requests, 3)
+	for _, req := range fake.requests {
+		assert.Contains(t, req.Prompt, "You review code.")
+		assert.Equal(t, []string{"--agent", "reviewer"}, req.Flags)
+		assert.Equal(t, 100, *req.Options.NumPredict)
+	}
+
+	for path, body := range requests {
+		body["model"] = "llama3"
+		w := postJSON(t, path, body)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		if path == "/v1/messages" {
+			assert.Contains(t, w.Body.String(), `"type":"not_found_error"`)
+		} else {
+			assert.Contains(t, w.Body.String(), `"code":"model_not_found"`)
+			assert.Contains(t, w.Body.String(), `The model 'llama3' does not exist`)
+		}
+	}
+	assert.Len(t, fake.requests, 3, "unknown models never reach q")
+
+	w := getJSON(t, "/v1/models/q-reviewer")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":"q-reviewer:latest"`)
+}
+
+func TestModelFlagsReachQ(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\necho \"$@\"\n")
+
+	response, err := (&QCLIBackend{}).Generate(t.Context(), QRequest{Prompt: "hi", Flags: []string{"--agent", "reviewer"}})
+
+	require.NoError(t, err)
+	assert.Equal(t, "chat --agent reviewer --message hi", response)
+}
@@ -0,0 +1,744 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI-compatible request/response structures
+type OpenAIChatRequest struct {
+	Model               string                `json:"model"`
+	Messages            []OpenAIMessage       `json:"messages"`
+	Stream              bool                  `json:"stream,omitempty"`
+	StreamOptions       *OpenAIStreamOptions  `json:"stream_options,omitempty"`
+	MaxCompletionTokens *int                  `json:"max_completion_tokens,omitempty"`
+	Tools               []Tool                `json:"tools,omitempty"`
+	ResponseFormat      *OpenAIResponseFormat `json:"response_format,omitempty"`
+	OpenAISampling
+}
+
+// OpenAIResponseFormat is response_format: text, json_object or json_schema
+type OpenAIResponseFormat struct {
+	Type       string            `json:"type"`
+	JSONSchema *OpenAIJSONSchema `json:"json_schema,omitempty"`
+}
+
+type OpenAIJSONSchema struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+	Strict      *bool           `json:"strict,omitempty"`
+}
+
+type OpenAICompletionRequest struct {
+	Model         string               `json:"model"`
+	Prompt        OpenAIPrompt         `json:"prompt"`
+	Suffix        string               `json:"suffix,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	StreamOptions *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	OpenAISampling
+}
+
+// OpenAISampling holds the sampling fields shared by chat and text completions
+type OpenAISampling struct {
+	Temperature      *float64   `json:"temperature,omitempty"`
+	TopP             *float64   `json:"top_p,omitempty"`
+	MaxTokens        *int       `json:"max_tokens,omitempty"`
+	Stop             OpenAIStop `json:"stop,omitempty"`
+	Seed             *int       `json:"seed,omitempty"`
+	FrequencyPenalty *float64   `json:"frequency_penalty,omitempty"`
+	PresencePenalty  *float64   `json:"presence_penalty,omitempty"`
+}
+
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type OpenAIMessage struct {
+	Role       string           `json:"role"`
+	Content    OpenAIContent    `json:"content"`
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// OpenAIToolCall is a tool call with JSON-encoded arguments. Index is only
+// set on streamed deltas.
+type OpenAIToolCall struct {
+	Index    *int               `json:"index,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+type OpenAIFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// OpenAIContent is message content: either a plain string or a list of
+// text and image_url parts. Images must be base64 data URLs.
+type OpenAIContent struct {
+	Text   string
+	Images []string
+}
+
+type openAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	ImageURL json.RawMessage `json:"image_url,omitempty"`
+}
+
+// OpenAIStop is a stop sequence or a list of them
+type OpenAIStop []string
+
+// OpenAIPrompt is a completion prompt: a string or a list holding one string
+type OpenAIPrompt string
+
+type OpenAIChatCompletion struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   *OpenAIUsage   `json:"usage,omitempty"`
+}
+
+type OpenAIChoice struct {
+	Index        int                    `json:"index"`
+	Message      *OpenAIResponseMessage `json:"message,omitempty"`
+	Delta        *OpenAIResponseMessage `json:"delta,omitempty"`
+	FinishReason *string                `json:"finish_reason"`
+}
+
+type OpenAICompletion struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []OpenAICompletionChoice `json:"choices"`
+	Usage   *OpenAIUsage             `json:"usage,omitempty"`
+}
+
+type OpenAICompletionChoice struct {
+	Index        int         `json:"index"`
+	Text         string      `json:"text"`
+	Logprobs     interface{} `json:"logprobs"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type OpenAIResponseMessage struct {
+	Role      string           `json:"role,omitempty"`
+	Content   string           `json:"content"`
+	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type OpenAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type OpenAIModelList struct {
+	Object string        `json:"object"`
+	Data   []OpenAIModel `json:"data"`
+}
+
+func (oc *OpenAIContent) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(data, &oc.Text); err == nil {
+		return nil
+	}
+
+	var parts []openAIContentPart
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return errors.New("content must be a string or an array of content parts")
+	}
+	var texts []string
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+		case "image_url":
+			image, err := decodeImageURL(part.ImageURL)
+			if err != nil {
+				return err
+			}
+			oc.Images = append(oc.Images, image)
+		default:
+			return fmt.Errorf("unsupported content part type %q", part.Type)
+		}
+	}
+	oc.Text = strings.Join(texts, "\n")
+	return nil
+}
+
+func (oc OpenAIContent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(oc.Text)
+}
+
+// decodeImageURL extracts the base64 payload of an image_url part, which may
+// be {"url": "..."} or a bare string
+func decodeImageURL(raw json.RawMessage) (string, error) {
+	var url string
+	if err := json.Unmarshal(raw, &url); err != nil {
+		var object struct {
+			URL string `json:"url"`
+		}
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return "", errors.New("image_url must be a string or an object with a url")
+		}
+		url = object.URL
+	}
+
+	header, payload, ok := strings.Cut(url, ",")
+	if !strings.HasPrefix(header, "data:") || !strings.HasSuffix(header, ";base64") || !ok {
+		return "", errors.New("image_url must be a base64 data URL; remote images are not supported")
+	}
+	if _, err := base64.StdEncoding.DecodeString(payload); err != nil {
+		return "", fmt.Errorf("invalid base64 image data: %v", err)
+	}
+	return payload, nil
+}
+
+func (s *OpenAIStop) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = OpenAIStop{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("stop must be a string or an array of strings")
+	}
+	*s = list
+	return nil
+}
+
+func (p *OpenAIPrompt) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*p = OpenAIPrompt(single)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("prompt must be a string or an array of strings")
+	}
+	if len(list) > 1 {
+		return errors.New("only one prompt per request is supported")
+	}
+	if len(list) == 1 {
+		*p = OpenAIPrompt(list[0])
+	}
+	return nil
+}
+
+// toChatRequest translates an OpenAI chat request onto the Ollama chat pipeline
+func (req OpenAIChatRequest) toChatRequest() (ChatRequest, error) {
+	messages := make([]Message, 0, len(req.Messages))
+	toolNames := map[string]string{}
+	for i, msg := range req.Messages {
+		role := msg.Role
+		if role == "developer" {
+			role = "system"
+		}
+		message := Message{Role: role, Content: msg.Content.Text, Images: msg.Content.Images}
+		for _, call := range msg.ToolCalls {
+			arguments, err := toolArguments(json.RawMessage(call.Function.Arguments))
+			if err != nil {
+				return ChatRequest{}, fmt.Errorf("messages[%d].tool_calls: %v", i, err)
+			}
+			toolNames[call.ID] = call.Function.Name
+			message.ToolCalls = append(message.ToolCalls, ToolCall{Function: FunctionCall{Name: call.Function.Name, Arguments: arguments}})
+		}
+		if role == "tool" {
+			message.ToolName = toolNames[msg.ToolCallID]
+		}
+		messages = append(messages, message)
+	}
+
+	sampling := req.OpenAISampling
+	if req.MaxCompletionTokens != nil {
+		sampling.MaxTokens = req.MaxCompletionTokens
+	}
+	format, err := req.ResponseFormat.format()
+	if err != nil {
+		return ChatRequest{}, err
+	}
+	return ChatRequest{Model: req.Model, Messages: messages, Format: format, Options: sampling.options(), Tools: req.Tools}, nil
+}
+
+// format maps response_format onto the Ollama format field
+func (f *OpenAIResponseFormat) format() (*Format, error) {
+	if f == nil {
+		return nil, nil
+	}
+	switch f.Type {
+	case "", "text":
+		return nil, nil
+	case "json_object":
+		return &Format{Type: formatJSON}, nil
+	case "json_schema":
+		if f.JSONSchema == nil || len(f.JSONSchema.Schema) == 0 {
+			return nil, errors.New("response_format.json_schema.schema is required")
+		}
+		format := &Format{}
+		if err := format.UnmarshalJSON(f.JSONSchema.Schema); err != nil {
+			return nil, fmt.Errorf("response_format: %v", err)
+		}
+		return format, nil
+	default:
+		return nil, fmt.Errorf("unsupported response_format type %q", f.Type)
+	}
+}
+
+// openAIToolCalls gives each tool call of a reply an id and encodes its
+// arguments
+func openAIToolCalls(calls []ToolCall) []OpenAIToolCall {
+	encoded := make([]OpenAIToolCall, 0, len(calls))
+	for _, call := range calls {
+		arguments, _ := json.Marshal(call.Function.Arguments)
+		encoded = append(encoded, OpenAIToolCall{
+			ID:       newCompletionID("call_"),
+			Type:     "function",
+			Function: OpenAIFunctionCall{Name: call.Function.Name, Arguments: string(arguments)},
+		})
+	}
+	return encoded
+}
+
+// toGenerateRequest translates an OpenAI text completion onto the Ollama
+// generate pipeline
+func (req OpenAICompletionRequest) toGenerateRequest() GenerateRequest {
+	return GenerateRequest{
+		Model:   req.Model,
+		Prompt:  string(req.Prompt),
+		Suffix:  req.Suffix,
+		Options: req.OpenAISampling.options(),
+	}
+}
+
+// options converts the sampling fields into Ollama options, or nil when the
+// client set none
+func (s OpenAISampling) options() *Options {
+	if s.Temperature == nil && s.TopP == nil && s.MaxTokens == nil && s.Seed == nil &&
+		s.FrequencyPenalty == nil && s.PresencePenalty == nil && len(s.Stop) == 0 {
+		return nil
+	}
+	return &Options{
+		Temperature:      s.Temperature,
+		TopP:             s.TopP,
+		NumPredict:       s.MaxTokens,
+		Seed:             s.Seed,
+		FrequencyPenalty: s.FrequencyPenalty,
+		PresencePenalty:  s.PresencePenalty,
+		Stop:             []string(s.Stop),
+	}
+}
+
+// Handle /v1/chat/completions endpoint
+func handleOpenAIChatCompletions(c *gin.Context) {
+	var req OpenAIChatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, err.Error(), "invalid_request")
+		return
+	}
+
+	chatReq, err := req.toChatRequest()
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, err.Error(), "invalid_request")
+		return
+	}
+	warnIgnoredOptions(c, chatReq.Options)
+	if !checkOpenAIModel(c, req.Model) {
+		return
+	}
+	if userMessage, images := lastUserMessage(chatReq.Messages); userMessage == "" && len(images) == 0 {
+		openAIError(c, http.StatusBadRequest, "No user message found", "invalid_request")
+		return
+	}
+	defer useModel(req.Model, config.KeepAlive)()
+
+	qreq := chatRequest(chatReq)
+	ctx, stats := newRequestStats(c.Request.Context(), qreq.Prompt)
+	completion := OpenAIChatCompletion{
+		ID:      newCompletionID("chatcmpl-"),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   openAIModel(req.Model),
+	}
+
+	if req.Stream && len(req.Tools) == 0 && !chatReq.Format.enabled() {
+		completion.Object = "chat.completion.chunk"
+		streamOpenAI(c, ctx, qreq, stats, req.StreamOptions, chatFrames{completion})
+		return
+	}
+
+	response, err := generateFormatted(ctx, qreq, chatReq.Format)
+	if err != nil {
+		respondOpenAIError(c, err)
+		return
+	}
+	metrics := stats.finish(response, doneStop)
+	reply := assistantMessage(response, req.Tools)
+	message := OpenAIResponseMessage{Role: "assistant", Content: reply.Content, ToolCalls: openAIToolCalls(reply.ToolCalls)}
+	reason := finishReason(metrics.DoneReason)
+	if len(reply.ToolCalls) > 0 {
+		toolCalls := "tool_calls"
+		reason = &toolCalls
+	}
+	if req.Stream {
+		// A tool call or formatted output is only recognisable in the
+		// complete output, so the whole reply is replayed as chunks once q
+		// has finished
+		completion.Object = "chat.completion.chunk"
+		replayOpenAIChat(c, chatFrames{completion}, message, reason, metrics, req.StreamOptions)
+		return
+	}
+	completion.Choices = []OpenAIChoice{{Message: &message, FinishReason: reason}}
+	completion.Usage = openAIUsage(metrics)
+	c.JSON(http.StatusOK, completion)
+}
+
+// replayOpenAIChat sends a finished reply as the chunks of a stream
+func replayOpenAIChat(c *gin.Context, frames chatFrames, message OpenAIResponseMessage, reason *string, metrics responseMetrics, streamOptions *OpenAIStreamOptions) {
+	writeSSE(c, "", frames.start())
+	if message.Content != "" {
+		writeSSE(c, "", frames.text(message.Content))
+	}
+	for i, call := range message.ToolCalls {
+		index := i
+		call.Index = &index
+		writeSSE(c, "", frames.delta(OpenAIResponseMessage{ToolCalls: []OpenAIToolCall{call}}, nil))
+	}
+	writeSSE(c, "", frames.finish(reason))
+	if streamOptions != nil && streamOptions.IncludeUsage {
+		writeSSE(c, "", frames.usage(openAIUsage(metrics)))
+	}
+	writeSSEDone(c)
+}
+
+// Handle /v1/completions endpoint
+func handleOpenAICompletions(c *gin.Context) {
+	var req OpenAICompletionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		openAIError(c, http.StatusBadRequest, err.Error(), "invalid_request")
+		return
+	}
+	if req.Prompt == "" {
+		openAIError(c, http.StatusBadRequest, "prompt is required", "invalid_request")
+		return
+	}
+
+	genReq := req.toGenerateRequest()
+	warnIgnoredOptions(c, genReq.Options)
+	if !checkOpenAIModel(c, req.Model) {
+		return
+	}
+	prompt, err := generatePrompt(genReq, messageTurns(resolveModel(req.Model).Messages))
+	if err != nil {
+		openAIError(c, http.StatusBadRequest, err.Error(), "invalid_request")
+		return
+	}
+	defer useModel(req.Model, config.KeepAlive)()
+
+	qreq := generateRequest(genReq, prompt)
+	ctx, stats := newRequestStats(c.Request.Context(), prompt)
+	completion := OpenAICompletion{
+		ID:      newCompletionID("cmpl-"),
+		Object:  "text_completion",
+		Created: time.Now().Unix(),
+		Model:   openAIModel(req.Model),
+	}
+
+	if req.Stream {
+		streamOpenAI(c, ctx, qreq, stats, req.StreamOptions, completionFrames{completion})
+		return
+	}
+
+	response, err := generate(ctx, qreq)
+	if err != nil {
+		respondOpenAIError(c, err)
+		return
+	}
+	metrics := stats.finish(response, doneStop)
+	completion.Choices = []OpenAICompletionChoice{{
+		Text:         response,
+		FinishReason: finishReason(metrics.DoneReason),
+	}}
+	completion.Usage = openAIUsage(metrics)
+	c.JSON(http.StatusOK, completion)
+}
+
+// openAIFrames builds the events of one kind of OpenAI stream
+type openAIFrames interface {
+	// start is sent before the first text, or nil when nothing is needed
+	start() interface{}
+	text(text string) interface{}
+	finish(reason *string) interface{}
+	usage(usage *OpenAIUsage) interface{}
+}
+
+type chatFrames struct {
+	completion OpenAIChatCompletion
+}
+
+func (f chatFrames) delta(delta OpenAIResponseMessage, reason *string) interface{} {
+	frame := f.completion
+	frame.Choices = []OpenAIChoice{{Delta: &delta, FinishReason: reason}}
+	return frame
+}
+
+func (f chatFrames) start() interface{} {
+	return f.delta(OpenAIResponseMessage{Role: "assistant"}, nil)
+}
+
+func (f chatFrames) text(text string) interface{} {
+	return f.delta(OpenAIResponseMessage{Content: text}, nil)
+}
+
+func (f chatFrames) finish(reason *string) interface{} {
+	return f.delta(OpenAIResponseMessage{}, reason)
+}
+
+func (f chatFrames) usage(usage *OpenAIUsage) interface{} {
+	frame := f.completion
+	frame.Choices = []OpenAIChoice{}
+	frame.Usage = usage
+	return frame
+}
+
+type completionFrames struct {
+	completion OpenAICompletion
+}
+
+func (f completionFrames) choice(text string, reason *string) interface{} {
+	frame := f.completion
+	frame.Choices = []OpenAICompletionChoice{{Text: text, FinishReason: reason}}
+	return frame
+}
+
+func (f completionFrames) start() interface{} {
+	return nil
+}
+
+func (f completionFrames) text(text string) interface{} {
+	return f.choice(text, nil)
+}
+
+func (f completionFrames) finish(reason *string) interface{} {
+	return f.choice("", reason)
+}
+
+func (f completionFrames) usage(usage *OpenAIUsage) interface{} {
+	frame := f.completion
+	frame.Choices = []OpenAICompletionChoice{}
+	frame.Usage = usage
+	return frame
+}
+
+// streamOpenAI sends a completion as server-sent events ending in [DONE]
+func streamOpenAI(c *gin.Context, ctx context.Context, qreq QRequest, stats *requestStats, streamOptions *OpenAIStreamOptions, frames openAIFrames) {
+	started := false
+	begin := func() error {
+		started = true
+		if start := frames.start(); start != nil {
+			return writeSSE(c, "", start)
+		}
+		return nil
+	}
+
+	var response strings.Builder
+	err := stream(ctx, qreq, func(text string) error {
+		if !started {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		response.WriteString(text)
+		return writeSSE(c, "", frames.text(text))
+	})
+	if err != nil && !started {
+		respondOpenAIError(c, err)
+		return
+	}
+
+	reason := doneReason(ctx, err)
+	switch reason {
+	case doneCancelled:
+		return
+	case doneError:
+		status, code := errorStatus(err)
+		writeSSE(c, "", openAIErrorBody(status, err.Error(), code))
+		writeSSEDone(c)
+		return
+	}
+
+	if !started {
+		begin()
+	}
+	metrics := stats.finish(response.String(), reason)
+	writeSSE(c, "", frames.finish(finishReason(reason)))
+	if streamOptions != nil && streamOptions.IncludeUsage {
+		writeSSE(c, "", frames.usage(openAIUsage(metrics)))
+	}
+	writeSSEDone(c)
+}
+
+// Handle /v1/models endpoint
+func handleOpenAIModels(c *gin.Context) {
+	models := availableModels()
+	list := OpenAIModelList{Object: "list", Data: make([]OpenAIModel, 0, len(models))}
+	for _, model := range models {
+		list.Data = append(list.Data, openAIModelInfo(model))
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// Handle /v1/models/:id endpoint
+func handleOpenAIModel(c *gin.Context) {
+	id := c.Param("id")
+	name := normalizeModelName(id)
+	for _, model := range availableModels() {
+		if name == model.Name || id == model.Model {
+			c.JSON(http.StatusOK, openAIModelInfo(model))
+			return
+		}
+	}
+	openAIModelNotFound(c, id)
+}
+
+// checkOpenAIModel reports whether name resolves, answering with OpenAI's
+// error shape when it does not
+func checkOpenAIModel(c *gin.Context, name string) bool {
+	_, err := registry.Resolve(name)
+	var notFound *ModelNotFoundError
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &notFound):
+		openAIModelNotFound(c, name)
+	default:
+		respondOpenAIError(c, err)
+	}
+	return false
+}
+
+func openAIModelNotFound(c *gin.Context, name string) {
+	openAIError(c, http.StatusNotFound, fmt.Sprintf("The model '%s' does not exist", name), "model_not_found")
+}
+
+func openAIModelInfo(model ModelInfo) OpenAIModel {
+	return OpenAIModel{
+		ID:      model.Name,
+		Object:  "model",
+		Created: model.ModifiedAt.Unix(),
+		OwnedBy: "amazon",
+	}
+}
+
+// openAIModel echoes the requested model name, as OpenAI does
+func openAIModel(model string) string {
+	if model == "" {
+		return "amazon-q"
+	}
+	return model
+}
+
+// finishReason maps done_reason onto OpenAI's finish_reason
+func finishReason(reason string) *string {
+	if reason != doneLength {
+		reason = "stop"
+	}
+	return &reason
+}
+
+func openAIUsage(m responseMetrics) *OpenAIUsage {
+	return &OpenAIUsage{
+		PromptTokens:     m.PromptEvalCount,
+		CompletionTokens: m.EvalCount,
+		TotalTokens:      m.PromptEvalCount + m.EvalCount,
+	}
+}
+
+// newCompletionID returns a unique response id such as chatcmpl-1a2b3c...
+func newCompletionID(prefix string) string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
+
+// respondOpenAIError writes a backend failure in OpenAI's error format
+func respondOpenAIError(c *gin.Context, err error) {
+	status, code := errorStatus(err)
+	setRetryAfter(c, status)
+	openAIError(c, status, err.Error(), code)
+}
+
+func openAIError(c *gin.Context, status int, message, code string) {
+	c.JSON(status, openAIErrorBody(status, message, code))
+}
+
+func openAIErrorBody(status int, message, code string) gin.H {
+	errType := "api_error"
+	switch {
+	case status == http.StatusUnauthorized:
+		errType = "authentication_error"
+	case status == http.StatusTooManyRequests:
+		errType = "rate_limit_error"
+	case status < 500:
+		errType = "invalid_request_error"
+	}
+	return gin.H{"error": gin.H{"message": message, "type": errType, "param": nil, "code": code}}
+}
+
+// writeSSE writes v as a server-sent event and flushes it to the client.
+// An empty event name sends a bare data line, as OpenAI does.
+func writeSSE(c *gin.Context, event string, v interface{}) error {
+	if !c.Writer.Written() {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+	}
+
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	fmt.Fprintf(&b, "data: %s\n\n", jsonData)
+	if _, err := c.Writer.WriteString(b.String()); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// writeSSEDone ends an OpenAI event stream
+func writeSSEDone(c *gin.Context) {
+	c.Writer.WriteString("data: [DONE]\n\n")
+	c.Writer.Flush()
+}
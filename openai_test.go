@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readSSE decodes the data of every server-sent event in body, stopping at [DONE]
+func readSSE(t *testing.T, body string) (events []map[string]interface{}, done bool) {
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			return events, true
+		}
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(data), &event), data)
+		events = append(events, event)
+	}
+	return events, false
+}
+
+func TestOpenAIChatCompletion(t *testing.T) {
+	r := useRegistry(t, newModelRegistry(""))
+	require.NoError(t, r.Put(VirtualModel{Name: "gpt-4o", From: "amazon-q"}))
+	fake := &fakeBackend{response: "Paris is the capital"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/v1/chat/completions", map[string]interface{}{
+		"model": "gpt-4o",
+		"messages": []map[string]interface{}{
+			{"role": "developer", "content": "Be brief"},
+			{"role": "user", "content": "Capital of France?"},
+		},
+		"temperature": 0.2,
+		"max_tokens":  64,
+		"stop":        "\n\n",
+	})
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var completion OpenAIChatCompletion
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &completion))
+	assert.True(t, strings.HasPrefix(completion.ID, "chatcmpl-"))
+	assert.Equal(t, "chat.completion", completion.Object)
+	assert.Equal(t, "gpt-4o", completion.Model)
+	assert.NotZero(t, completion.Created)
+	require.Len(t, completion.Choices, 1)
+	assert.Equal(t, "assistant", completion.Choices[0].Message.Role)
+	assert.Equal(t, "Paris is the capital", completion.Choices[0].Message.Content)
+	assert.Equal(t, "stop", *completion.Choices[0].FinishReason)
+	require.NotNil(t, completion.Usage)
+	assert.Equal(t, 4, completion.Usage.CompletionTokens)
+	assert.Equal(t, completion.Usage.PromptTokens+4, completion.Usage.TotalTokens)
+
+	require.Len(t, fake.requests, 1)
+	assert.Contains(t, fake.requests[0].Prompt, "Be brief")
+	assert.Contains(t, fake.requests[0].Prompt, "Capital of France?")
+	assert.Equal(t, 0.2, *fake.requests[0].Options.Temperature)
+	assert.Equal(t, 64, *fake.requests[0].Options.NumPredict)
+	assert.Equal(t, []string{"\n\n"}, fake.requests[0].Options.Stop)
+}
+
+func TestOpenAIContentParts(t *testing.T) {
+	fake := &fakeBackend{response: "A cat"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/v1/chat/completions", map[string]interface{}{
+		"model": "amazon-q",
+		"messages": []map[string]interface{}{{
+			"role": "user",
+			"content": []map[string]interface{}{
+				{"type": "text", "text": "What is in this image?"},
+				{"type": "image_url", "image_url": map[string]string{"url": "data:image/png;base64,aW1n"}},
+			},
+		}},
+	})
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Len(t, fake.requests, 1)
+	assert.Contains(t, fake.requests[0].Prompt, "What is in this image?")
+	assert.Equal(t, []string{"aW1n"}, fake.requests[0].Images)
+}
+
+func TestOpenAIRejectsInvalidRequests(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "unused"})
+
+	cases := []struct {
+		name    string
+		content interface{}
+		message string
+	}{
+		{"remote image", []map[string]interface{}{
+			{"type": "image_url", "image_url": map[string]string{"url": "https://example.com/cat.png"}},
+		}, "data URL"},
+		{"unknown part", []map[string]interface{}{{"type": "input_audio"}}, "input_audio"},
+		{"no user message", nil, "No user message found"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			messages := []map[string]interface{}{{"role": "system", "content": "You are helpful"}}
+			if tc.content != nil {
+				messages = append(messages, map[string]interface{}{"role": "user", "content": tc.content})
+			}
+			w := postJSON(t, "/v1/chat/completions", map[string]interface{}{"model": "amazon-q", "messages": messages})
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var body struct {
+				Error struct {
+					Message string `json:"message"`
+					Type    string `json:"type"`
+				} `json:"error"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.Contains(t, body.Error.Message, tc.message)
+			assert.Equal(t, "invalid_request_error", body.Error.Type)
+		})
+	}
+}
+
+func TestOpenAIBackendError(t *testing.T) {
+	useBackend(t, &fakeBackend{err: &QError{Kind: QErrThrottled, Message: "Amazon Q is throttling requests; retry later"}})
+
+	w := postJSON(t, "/v1/chat/completions", map[string]interface{}{
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+	})
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), `"type":"rate_limit_error"`)
+	assert.Contains(t, w.Body.String(), `"code":"throttled"`)
+}
+
+func TestOpenAIResponseFormat(t *testing.T) {
+	fake := &fakeBackend{response: "Here it is: {\"name\": \"Ada\"}"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/v1/chat/completions", map[string]interface{}{
+		"messages": []map[string]string{{"role": "user", "content": "Who wrote the first program?"}},
+		"response_format": map[string]interface{}{
+			"type": "json_schema",
+			"json_schema": map[string]interface{}{
+				"name":   "person",
+				"schema": json.RawMessage(`{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`),
+			},
+		},
+	})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var completion OpenAIChatCompletion
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &completion))
+	assert.Equal(t, `{"name": "Ada"}`, completion.Choices[0].Message.Content)
+	require.Len(t, fake.requests, 1)
+	assert.Contains(t, fake.requests[0].Prompt, "must match this JSON Schema")
+
+	w = postJSON(t, "/v1/chat/completions", map[string]interface{}{
+		"messages":        []map[string]string{{"role": "user", "content": "hi"}},
+		"response_format": map[string]string{"type": "json_schema"},
+	})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "json_schema.schema is required")
+}
+
+func TestOpenAIStreaming(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"Hello", " there"}})
+
+	w := postJSON(t, "/v1/chat/completions", map[string]interface{}{
+		"model":          "amazon-q",
+		"messages":       []map[string]string{{"role": "user", "content": "hi"}},
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	events, done := readSSE(t, w.Body.String())
+	assert.True(t, done, "stream must end with [DONE]")
+	require.Len(t, events, 5)
+
+	id := events[0]["id"]
+	for _, event := range events {
+		assert.Equal(t, "chat.completion.chunk", event["object"])
+		assert.Equal(t, id, event["id"], "every chunk shares the completion id")
+	}
+	delta := func(i int) map[string]interface{} {
+		return events[i]["choices"].([]interface{})[0].(map[string]interface{})["delta"].(map[string]interface{})
+	}
+	assert.Equal(t, "assistant", delta(0)["role"])
+	assert.Equal(t, "Hello", delta(1)["content"])
+	assert.Equal(t, " there", delta(2)["content"])
+
+	final := events[3]["choices"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "stop", final["finish_reason"])
+
+	assert.Empty(t, events[4]["choices"])
+	usage := events[4]["usage"].(map[string]interface{})
+	assert.Equal(t, float64(2), usage["completion_tokens"])
+}
+
+func TestOpenAIStreamingFailureAfterOutput(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"partial"}, err: errors.New("boom")})
+
+	w := postJSON(t, "/v1/chat/completions", map[string]interface{}{
+		"messages": []map[string]string{{"role": "user", "content": "hi"}},
+		"stream":   true,
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	events, done := readSSE(t, w.Body.String())
+	assert.True(t, done)
+	require.Len(t, events, 3)
+	assert.Contains(t, events[2]["error"].(map[string]interface{})["message"], "boom")
+}
+
+func TestOpenAITextCompletion(t *testing.T) {
+	r := useRegistry(t, newModelRegistry(""))
+	require.NoError(t, r.Put(VirtualModel{Name: "gpt-3.5-turbo-instruct", From: "amazon-q"}))
+	fake := &fakeBackend{response: "return a + b"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/v1/completions", map[string]interface{}{
+		"model":      "gpt-3.5-turbo-instruct",
+		"prompt":     []string{"def add(a, b):"},
+		"suffix":     "\n\nprint(add(1, 2))",
+		"max_tokens": 32,
+		"stop":       []string{"\n\n"},
+	})
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var completion OpenAICompletion
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &completion))
+	assert.True(t, strings.HasPrefix(completion.ID, "cmpl-"))
+	assert.Equal(t, "text_completion", completion.Object)
+	assert.Equal(t, "gpt-3.5-turbo-instruct", completion.Model)
+	require.Len(t, completion.Choices, 1)
+	assert.Equal(t, "return a + b", completion.Choices[0].Text)
+	assert.Equal(t, "stop", *completion.Choices[0].FinishReason)
+	assert.Equal(t, 4, completion.Usage.CompletionTokens)
+
+	require.Len(t, fake.requests, 1)
+	assert.Contains(t, fake.requests[0].Prompt, "<prefix>def add(a, b):</prefix>")
+	assert.Contains(t, fake.requests[0].Prompt, "<suffix>\n\nprint(add(1, 2))</suffix>")
+	assert.Equal(t, 32, *fake.requests[0].Options.NumPredict)
+	assert.Equal(t, []string{"\n\n"}, fake.requests[0].Options.Stop)
+}
+
+func TestOpenAITextCompletionRejectsInvalidPrompts(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "unused"})
+
+	for name, prompt := range map[string]interface{}{
+		"missing":  "",
+		"multiple": []string{"one", "two"},
+		"tokens":   []int{1, 2, 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := postJSON(t, "/v1/completions", map[string]interface{}{"model": "amazon-q", "prompt": prompt})
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), `"type":"invalid_request_error"`)
+		})
+	}
+}
+
+func TestOpenAITextCompletionStreaming(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"return", " a + b"}})
+
+	w := postJSON(t, "/v1/completions", map[string]interface{}{
+		"prompt":         "def add(a, b):",
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	events, done := readSSE(t, w.Body.String())
+	assert.True(t, done)
+	require.Len(t, events, 4)
+	choice := func(i int) map[string]interface{} {
+		return events[i]["choices"].([]interface{})[0].(map[string]interface{})
+	}
+	assert.Equal(t, "text_completion", events[0]["object"])
+	assert.Equal(t, "return", choice(0)["text"])
+	assert.Nil(t, choice(0)["finish_reason"])
+	assert.Equal(t, " a + b", choice(1)["text"])
+	assert.Equal(t, "stop", choice(2)["finish_reason"])
+	assert.Equal(t, float64(4), events[3]["usage"].(map[string]interface{})["completion_tokens"])
+}
+
+func TestOpenAIModels(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/models", nil)
+	setupRouter().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var list OpenAIModelList
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	assert.Equal(t, "list", list.Object)
+	require.Len(t, list.Data, len(availableModels()))
+	assert.Equal(t, "amazon-q:latest", list.Data[0].ID)
+	assert.Equal(t, "model", list.Data[0].Object)
+
+	for id, status := range map[string]int{"amazon-q:latest": 200, "amazon-q": 200, "gpt-4": 404} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/models/"+id, nil)
+		setupRouter().ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, id)
+		if status == 200 {
+			assert.Contains(t, w.Body.String(), `"id":"amazon-q:latest"`)
+		} else {
+			assert.Contains(t, w.Body.String(), `"code":"model_not_found"`)
+		}
+	}
+}
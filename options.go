@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Options are the generation options of a request. q exposes no sampling
+// controls, so num_predict and stop are enforced on its output, temperature
+// becomes a hint in the prompt, and the rest are reported as ignored.
+type Options struct {
+	NumPredict       *int
+	Stop             []string
+	Temperature      *float64
+	TopP             *float64
+	TopK             *int
+	Seed             *int
+	FrequencyPenalty *float64
+	PresencePenalty  *float64
+	RepeatPenalty    *float64
+	// Ignored lists the options the proxy has no use for
+	Ignored []string
+}
+
+// Prompt hints standing in for temperature
+const (
+	lowTemperatureHint  = "\n\nAnswer precisely and consistently; avoid speculation and embellishment."
+	highTemperatureHint = "\n\nFeel free to be creative and original in your answer."
+)
+
+// errOutputLimit stops q once num_predict or a stop sequence ends the output
+var errOutputLimit = errors.New("output limit reached")
+
+func (o *Options) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return errors.New("options must be an object")
+	}
+	*o = Options{}
+	ints := map[string]**int{"num_predict": &o.NumPredict, "top_k": &o.TopK, "seed": &o.Seed}
+	floats := map[string]**float64{
+		"temperature":       &o.Temperature,
+		"top_p":             &o.TopP,
+		"frequency_penalty": &o.FrequencyPenalty,
+		"presence_penalty":  &o.PresencePenalty,
+		"repeat_penalty":    &o.RepeatPenalty,
+	}
+	for name, raw := range fields {
+		if string(raw) == "null" {
+			continue
+		}
+		if field, ok := ints[name]; ok {
+			var n float64
+			if err := json.Unmarshal(raw, &n); err != nil || n != math.Trunc(n) {
+				return fmt.Errorf("option %q must be an integer", name)
+			}
+			v := int(n)
+			*field = &v
+		} else if field, ok := floats[name]; ok {
+			var v float64
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return fmt.Errorf("option %q must be a number", name)
+			}
+			*field = &v
+		} else if name == "stop" {
+			stop, err := decodeStop(raw)
+			if err != nil {
+				return err
+			}
+			o.Stop = stop
+		} else {
+			o.Ignored = append(o.Ignored, name)
+		}
+	}
+	sort.Strings(o.Ignored)
+	return nil
+}
+
+// decodeStop reads stop as a string or a list of strings, dropping empty ones
+func decodeStop(raw json.RawMessage) ([]string, error) {
+	var stop []string
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		stop = []string{single}
+	} else if err := json.Unmarshal(raw, &stop); err != nil {
+		return nil, errors.New(`option "stop" must be a string or a list of strings`)
+	}
+	kept := stop[:0]
+	for _, s := range stop {
+		if s != "" {
+			kept = append(kept, s)
+		}
+	}
+	return kept, nil
+}
+
+func (o Options) MarshalJSON() ([]byte, error) {
+	fields := map[string]interface{}{}
+	for name, value := range map[string]interface{}{
+		"num_predict":       o.NumPredict,
+		"top_k":             o.TopK,
+		"seed":              o.Seed,
+		"temperature":       o.Temperature,
+		"top_p":             o.TopP,
+		"frequency_penalty": o.FrequencyPenalty,
+		"presence_penalty":  o.PresencePenalty,
+		"repeat_penalty":    o.RepeatPenalty,
+	} {
+		switch v := value.(type) {
+		case *int:
+			if v != nil {
+				fields[name] = *v
+			}
+		case *float64:
+			if v != nil {
+				fields[name] = *v
+			}
+		}
+	}
+	if len(o.Stop) > 0 {
+		fields["stop"] = o.Stop
+	}
+	return json.Marshal(fields)
+}
+
+// mergeOptions layers override on top of base, such as request options on
+// a model's defaults
+func mergeOptions(base, override *Options) *Options {
+	if base == nil {
+		return override
+	}
+	if override == nil {
+		return base
+	}
+	merged := *base
+	for _, field := range []struct{ to, from **int }{
+		{&merged.NumPredict, &override.NumPredict},
+		{&merged.TopK, &override.TopK},
+		{&merged.Seed, &override.Seed},
+	} {
+		if *field.from != nil {
+			*field.to = *field.from
+		}
+	}
+	for _, field := range []struct{ to, from **float64 }{
+		{&merged.Temperature, &override.Temperature},
+		{&merged.TopP, &override.TopP},
+		{&merged.FrequencyPenalty, &override.FrequencyPenalty},
+		{&merged.PresencePenalty, &override.PresencePenalty},
+		{&merged.RepeatPenalty, &override.RepeatPenalty},
+	} {
+		if *field.from != nil {
+			*field.to = *field.from
+		}
+	}
+	if len(override.Stop) > 0 {
+		merged.Stop = override.Stop
+	}
+	merged.Ignored = append(append([]string(nil), base.Ignored...), override.Ignored...)
+	return &merged
+}
+
+// limitsOutput reports whether q's output must be cut short
+func (o *Options) limitsOutput() bool {
+	return o != nil && (o.maxTokens() > 0 || len(o.Stop) > 0)
+}
+
+// maxTokens is num_predict, or 0 when output is unlimited. As in Ollama,
+// negative values mean no limit.
+func (o *Options) maxTokens() int {
+	if o == nil || o.NumPredict == nil || *o.NumPredict < 0 {
+		return 0
+	}
+	return *o.NumPredict
+}
+
+// promptHint is appended to the prompt in place of temperature
+func (o *Options) promptHint() string {
+	switch {
+	case o == nil || o.Temperature == nil:
+		return ""
+	case *o.Temperature <= 0.3:
+		return lowTemperatureHint
+	case *o.Temperature >= 1.0:
+		return highTemperatureHint
+	default:
+		return ""
+	}
+}
+
+// unsupported names the options that have no effect on q
+func (o *Options) unsupported() []string {
+	if o == nil {
+		return nil
+	}
+	var names []string
+	for name, set := range map[string]bool{
+		"top_p":             o.TopP != nil,
+		"top_k":             o.TopK != nil,
+		"seed":              o.Seed != nil,
+		"frequency_penalty": o.FrequencyPenalty != nil,
+		"presence_penalty":  o.PresencePenalty != nil,
+		"repeat_penalty":    o.RepeatPenalty != nil,
+	} {
+		if set {
+			names = append(names, name)
+		}
+	}
+	names = append(names, o.Ignored...)
+	sort.Strings(names)
+	return names
+}
+
+// warnIgnoredOptions reports options q cannot honour in a Warning header
+func warnIgnoredOptions(c *gin.Context, options *Options) {
+	names := options.unsupported()
+	if len(names) == 0 {
+		return
+	}
+	message := "options not supported by Amazon Q were ignored: " + strings.Join(names, ", ")
+	log.Print(message)
+	c.Header("Warning", fmt.Sprintf("299 amazon-q-ollama %q", message))
+}
+
+// generate runs req through the backend with its options applied
+func generate(ctx context.Context, req QRequest) (string, error) {
+	req.Prompt += req.Options.promptHint()
+	if !req.Options.limitsOutput() {
+		return backend.Generate(ctx, req)
+	}
+	// Streaming lets q be stopped as soon as the limit is reached
+	var response strings.Builder
+	err := streamLimited(ctx, req, func(chunk string) error {
+		response.WriteString(chunk)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response.String()), nil
+}
+
+// stream runs req through the backend with its options applied
+func stream(ctx context.Context, req QRequest, onChunk func(chunk string) error) error {
+	req.Prompt += req.Options.promptHint()
+	return streamLimited(ctx, req, onChunk)
+}
+
+// streamLimited streams req, ending the output at num_predict tokens or the
+// first stop sequence. q is killed once the output is complete, and a
+// truncated response is reported as done_reason "length".
+func streamLimited(ctx context.Context, req QRequest, onChunk func(chunk string) error) error {
+	if !req.Options.limitsOutput() {
+		return backend.Stream(ctx, req, onChunk)
+	}
+	limiter := &outputLimiter{maxTokens: req.Options.maxTokens(), stop: req.Options.Stop, emit: onChunk}
+	err := backend.Stream(ctx, req, limiter.Write)
+	if limiter.done {
+		if limiter.truncated {
+			markTruncated(ctx)
+		} else if limiter.matched != "" {
+			markStopSequence(ctx, limiter.matched)
+		}
+		return nil
+	}
+	// Text held back as a possible stop sequence is delivered either way
+	if flushErr := limiter.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// outputLimiter passes output on until num_predict tokens or a stop
+// sequence. Text that could be the start of a stop sequence is held back
+// until the next chunk shows whether it is one.
+type outputLimiter struct {
+	maxTokens int
+	stop      []string
+	emit      func(chunk string) error
+
+	held      string
+	tokens    int
+	inToken   bool
+	done      bool
+	truncated bool
+	// matched is the stop sequence that ended the output
+	matched string
+}
+
+func (l *outputLimiter) Write(chunk string) error {
+	if l.done {
+		return errOutputLimit
+	}
+	text := l.held + chunk
+	l.held = ""
+	if i, stop := l.stopIndex(text); i >= 0 {
+		l.done = true
+		if err := l.emitCounted(text[:i]); err != nil {
+			return err
+		}
+		// Reaching num_predict first means the stop sequence was never output
+		if !l.truncated {
+			l.matched = stop
+		}
+		return errOutputLimit
+	}
+	keep := len(text) - l.partialStop(text)
+	l.held = text[keep:]
+	if err := l.emitCounted(text[:keep]); err != nil {
+		return err
+	}
+	if l.done {
+		return errOutputLimit
+	}
+	return nil
+}
+
+// Flush delivers any held-back text once q has finished
+func (l *outputLimiter) Flush() error {
+	text := l.held
+	l.held = ""
+	if l.done || text == "" {
+		return nil
+	}
+	return l.emitCounted(text)
+}
+
+// emitCounted passes text on, cutting it before the first token past
+// maxTokens. Tokens are counted the way countTokens counts them.
+func (l *outputLimiter) emitCounted(text string) error {
+	if l.maxTokens > 0 {
+		for i, r := range text {
+			space := unicode.IsSpace(r)
+			if !space && !l.inToken {
+				if l.tokens == l.maxTokens {
+					text = text[:i]
+					l.done, l.truncated = true, true
+					break
+				}
+				l.tokens++
+			}
+			l.inToken = !space
+		}
+	}
+	if text == "" {
+		return nil
+	}
+	return l.emit(text)
+}
+
+// stopIndex returns where the earliest stop sequence in text begins and
+// which sequence it is, or -1
+func (l *outputLimiter) stopIndex(text string) (int, string) {
+	index, stop := -1, ""
+	for _, s := range l.stop {
+		if i := strings.Index(text, s); i >= 0 && (index < 0 || i < index) {
+			index, stop = i, s
+		}
+	}
+	return index, stop
+}
+
+// partialStop returns the length of the longest suffix of text that begins
+// a stop sequence
+func (l *outputLimiter) partialStop(text string) int {
+	longest := 0
+	for _, s := range l.stop {
+		for n := min(len(s)-1, len(text)); n > longest; n-- {
+			if strings.HasSuffix(text, s[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
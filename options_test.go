@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionsUnmarshal(t *testing.T) {
+	var options Options
+	require.NoError(t, json.Unmarshal([]byte(`{"num_predict": 10, "stop": "END", "temperature": 0.1, "seed": 42, "num_ctx": 4096, "mirostat": 1}`), &options))
+	assert.Equal(t, 10, *options.NumPredict)
+	assert.Equal(t, []string{"END"}, options.Stop)
+	assert.Equal(t, 0.1, *options.Temperature)
+	assert.Equal(t, 42, *options.Seed)
+	assert.Equal(t, []string{"mirostat", "num_ctx"}, options.Ignored)
+	assert.Equal(t, []string{"mirostat", "num_ctx", "seed"}, options.unsupported())
+
+	require.NoError(t, json.Unmarshal([]byte(`{"stop": ["a", "", "b"]}`), &options))
+	assert.Equal(t, []string{"a", "b"}, options.Stop)
+	assert.Nil(t, options.NumPredict, "fields are reset on every decode")
+
+	for raw, message := range map[string]string{
+		`{"num_predict": "ten"}`: `option "num_predict" must be an integer`,
+		`{"num_predict": 1.5}`:   `option "num_predict" must be an integer`,
+		`{"temperature": "hot"}`: `option "temperature" must be a number`,
+		`{"stop": 3}`:            `option "stop" must be a string or a list of strings`,
+		`[]`:                     "options must be an object",
+	} {
+		assert.EqualError(t, json.Unmarshal([]byte(raw), &options), message, raw)
+	}
+}
+
+func TestOptionsPromptHint(t *testing.T) {
+	temperature := func(v float64) *Options { return &Options{Temperature: &v} }
+	assert.Equal(t, lowTemperatureHint, temperature(0).promptHint())
+	assert.Equal(t, "", temperature(0.7).promptHint())
+	assert.Equal(t, highTemperatureHint, temperature(1.2).promptHint())
+	assert.Equal(t, "", (*Options)(nil).promptHint())
+}
+
+func TestOutputLimiter(t *testing.T) {
+	cases := []struct {
+		name      string
+		maxTokens int
+		stop      []string
+		chunks    []string
+		want      string
+		truncated bool
+	}{
+		{"no limit reached", 5, nil, []string{"one two"}, "one two", false},
+		{"num_predict across chunks", 3, nil, []string{"one tw", "o three fo", "ur five"}, "one two three ", true},
+		{"exactly num_predict", 2, nil, []string{"one ", "two\n"}, "one two\n", false},
+		{"stop in a chunk", 0, []string{"STOP"}, []string{"hello STOP world"}, "hello ", false},
+		{"stop across chunks", 0, []string{"</answer>"}, []string{"42</ans", "wer> trailing"}, "42", false},
+		{"earliest stop wins", 0, []string{"bb", "a"}, []string{"xxbba"}, "xx", false},
+		{"partial stop released", 0, []string{"END"}, []string{"the EN", "D-user wins"}, "the ", false},
+		{"held text flushed at the end", 0, []string{"END"}, []string{"almost E"}, "almost E", false},
+		{"stop before num_predict", 10, []string{"."}, []string{"One. Two."}, "One", false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			limiter := &outputLimiter{maxTokens: tc.maxTokens, stop: tc.stop, emit: func(chunk string) error {
+				assert.NotEmpty(t, chunk)
+				out.WriteString(chunk)
+				return nil
+			}}
+			for _, chunk := range tc.chunks {
+				if err := limiter.Write(chunk); err != nil {
+					require.ErrorIs(t, err, errOutputLimit)
+					break
+				}
+			}
+			require.NoError(t, limiter.Flush())
+			assert.Equal(t, tc.want, out.String())
+			assert.Equal(t, tc.truncated, limiter.truncated)
+		})
+	}
+}
+
+func TestNumPredictStopsStreaming(t *testing.T) {
+	fake := &fakeBackend{chunks: []string{"one two ", "three four ", "five six"}}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/api/generate", map[string]interface{}{
+		"model":   "amazon-q",
+		"prompt":  "count",
+		"options": map[string]interface{}{"num_predict": 3},
+	})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 3)
+	assert.Equal(t, "one two ", frames[0]["response"])
+	assert.Equal(t, "three ", frames[1]["response"])
+	assert.Equal(t, "length", frames[2]["done_reason"])
+	assert.Equal(t, float64(3), frames[2]["eval_count"])
+}
+
+func TestStopSequenceEndsChat(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"The answer is 4", "2.\nUser: and", " then?"}})
+
+	w := postJSON(t, "/api/chat", map[string]interface{}{
+		"model":    "amazon-q",
+		"messages": []Message{{Role: "user", Content: "hi"}},
+		"stream":   false,
+		"options":  map[string]interface{}{"stop": []string{"\nUser:"}},
+	})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response ChatResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "The answer is 42.", response.Message.Content)
+	assert.Equal(t, doneStop, response.DoneReason)
+}
+
+func TestNumPredictKillsQ(t *testing.T) {
+	useBackend(t, &QCLIBackend{})
+	useFakeQ(t, "#!/bin/sh\necho 'one two three four'\nsleep 30\n")
+
+	start := time.Now()
+	w := postJSON(t, "/api/generate", map[string]interface{}{
+		"model":   "amazon-q",
+		"prompt":  "count",
+		"stream":  false,
+		"options": map[string]interface{}{"num_predict": 2},
+	})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Less(t, time.Since(start), 10*time.Second, "q should be killed once num_predict is reached")
+	var response GenerateResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "one two", response.Response)
+	assert.Equal(t, doneLength, response.DoneReason)
+}
+
+func TestOptionsHintsAndWarnings(t *testing.T) {
+	fake := &fakeBackend{response: "ok"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/api/generate", map[string]interface{}{
+		"model":   "amazon-q",
+		"prompt":  "hi",
+		"stream":  false,
+		"options": map[string]interface{}{"temperature": 0, "seed": 7, "num_ctx": 2048},
+	})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `299 amazon-q-ollama "options not supported by Amazon Q were ignored: num_ctx, seed"`, w.Header().Get("Warning"))
+	require.Len(t, fake.requests, 1)
+	assert.True(t, strings.HasSuffix(fake.requests[0].Prompt, lowTemperatureHint))
+
+	w = postJSON(t, "/api/generate", map[string]interface{}{"model": "amazon-q", "prompt": "hi", "options": map[string]interface{}{"num_predict": "many"}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "must be an integer")
+}
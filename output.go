@@ -0,0 +1,96 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ansiPattern matches CSI sequences, OSC sequences and two-byte escapes
+var ansiPattern = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[()][0-9A-Za-z]|[@-Z\\-_])`)
+
+// chromePatterns match q CLI banner and hint lines that are not part of the answer
+var chromePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^To exit the CLI, press Ctrl\+C`),
+	regexp.MustCompile(`^🤖 You are chatting with `),
+	regexp.MustCompile(`^Welcome to Amazon Q`),
+	regexp.MustCompile(`^/help all commands`),
+	regexp.MustCompile(`^ctrl \+ j new lines`),
+	regexp.MustCompile(`^━+$`),
+}
+
+// stripANSI removes terminal escape sequences and stray control bytes
+func stripANSI(s string) string {
+	s = ansiPattern.ReplaceAllString(s, "")
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\n' && r != '\r' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// isSpinnerLine reports whether line is a progress spinner frame
+func isSpinnerLine(line string) bool {
+	r, _ := utf8.DecodeRuneInString(line)
+	if r >= 0x2800 && r <= 0x28FF {
+		return true
+	}
+	return strings.ContainsRune("|/-\\", r) && strings.Contains(line, "Thinking")
+}
+
+func isChromeLine(line string) bool {
+	for _, pattern := range chromePatterns {
+		if pattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// outputParser turns raw q output into clean assistant text one line at a
+// time, so the same rules apply to buffered and streamed responses
+type outputParser struct {
+	started bool
+}
+
+func newOutputParser() *outputParser {
+	return &outputParser{}
+}
+
+// ParseLine cleans a single line of output. ok is false when the line is
+// terminal chrome that should be dropped entirely.
+func (p *outputParser) ParseLine(line string) (text string, ok bool) {
+	line = stripANSI(line)
+	line = strings.TrimSuffix(line, "\r")
+	// A carriage return redraws the line; only the final redraw is visible
+	if i := strings.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+
+	trimmed := strings.TrimSpace(line)
+	if isSpinnerLine(trimmed) || isChromeLine(trimmed) {
+		return "", false
+	}
+
+	if !p.started {
+		if trimmed == "" || trimmed == ">" {
+			return "", false
+		}
+		line = strings.TrimPrefix(line, poolPromptMarker)
+		p.started = true
+	}
+	return line, true
+}
+
+// cleanQOutput converts a complete q response into assistant text
+func cleanQOutput(raw string) string {
+	parser := newOutputParser()
+	var lines []string
+	for _, line := range strings.Split(raw, "\n") {
+		if text, ok := parser.ParseLine(line); ok {
+			lines = append(lines, text)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
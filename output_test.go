@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCleanQOutputFixtures runs every testdata/qoutput/*.raw capture through
+// the parser and compares it with the matching .golden file
+func TestCleanQOutputFixtures(t *testing.T) {
+	raws, err := filepath.Glob(filepath.Join("testdata", "qoutput", "*.raw"))
+	require.NoError(t, err)
+	require.NotEmpty(t, raws)
+
+	for _, rawPath := range raws {
+		name := strings.TrimSuffix(filepath.Base(rawPath), ".raw")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(rawPath)
+			require.NoError(t, err)
+			golden, err := os.ReadFile(strings.TrimSuffix(rawPath, ".raw") + ".golden")
+			require.NoError(t, err)
+
+			assert.Equal(t, string(golden), cleanQOutput(string(raw)))
+		})
+	}
+}
+
+func TestOutputParserLineByLine(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("testdata", "qoutput", "spinner.raw"))
+	require.NoError(t, err)
+
+	parser := newOutputParser()
+	var kept []string
+	for _, line := range strings.Split(string(raw), "\n") {
+		if text, ok := parser.ParseLine(line); ok && text != "" {
+			kept = append(kept, text)
+		}
+	}
+	assert.Equal(t, []string{"The bucket policy looks fine."}, kept)
+}
+
+func TestStripANSI(t *testing.T) {
+	assert.Equal(t, "red plain", stripANSI("\x1b[31mred\x1b[0m plain"))
+	assert.Equal(t, "tab\tkept", stripANSI("tab\tkept\x07"))
+}
+
+func TestStreamCleansOutput(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\nprintf '\\033[?25l\\342\\240\\213 Thinking...\\r\\033[2K\\033[?25h\\n\\033[35m> \\033[0mclean answer\\n' \necho 'noise' >&2\n")
+
+	var chunks []string
+	err := (&QCLIBackend{}).Stream(t.Context(), QRequest{Prompt: "hi"}, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"clean answer"}, chunks)
+
+	response, err := executeQCommand(t.Context(), "hi", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "clean answer", response)
+}
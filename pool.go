@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// poolPromptMarker is printed by q chat when it is ready for the next message
+	poolPromptMarker = "> "
+	// promptSettle is how long output must stay quiet after the prompt marker
+	// before the response is considered complete
+	promptSettle = 50 * time.Millisecond
+	// workerStartTimeout bounds how long a new worker may take to show its first prompt
+	workerStartTimeout = 30 * time.Second
+	// resetTimeout bounds how long a conversation reset may take
+	resetTimeout = 10 * time.Second
+)
+
+var (
+	errWorkerExited  = errors.New("q worker exited")
+	errWorkerTimeout = errors.New("q worker did not become ready in time")
+)
+
+var (
+	// qpool is the shared worker pool; nil when the pool is disabled or
+	// the model is not loaded
+	qpool   *qPool
+	qpoolMu sync.RWMutex
+)
+
+// activePool returns the shared worker pool, or nil when there is none
+func activePool() *qPool {
+	qpoolMu.RLock()
+	defer qpoolMu.RUnlock()
+	return qpool
+}
+
+// setPool installs p as the shared worker pool and returns the previous one
+func setPool(p *qPool) *qPool {
+	qpoolMu.Lock()
+	defer qpoolMu.Unlock()
+	previous := qpool
+	qpool = p
+	return previous
+}
+
+// qWorker is a long-lived interactive q chat process driven over stdin/stdout
+type qWorker struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	output   chan []byte
+	exited   chan struct{}
+	quit     chan struct{}
+	quitOnce sync.Once
+	requests int
+}
+
+func startQWorker(binary string) (*qWorker, error) {
+	cmd := exec.Command(binary, "chat")
+	setProcessGroup(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	w := &qWorker{
+		cmd:    cmd,
+		stdin:  stdin,
+		output: make(chan []byte, 64),
+		exited: make(chan struct{}),
+		quit:   make(chan struct{}),
+	}
+	go w.readLoop(stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), workerStartTimeout)
+	defer cancel()
+	if err := w.readUntilPrompt(ctx, nil); err != nil {
+		w.close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// readLoop forwards stdout to the output channel until the process exits
+func (w *qWorker) readLoop(stdout io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			chunk := make([]byte, n)
+			copy(chunk, buf[:n])
+			select {
+			case w.output <- chunk:
+			case <-w.quit:
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	close(w.output)
+	w.cmd.Wait()
+	close(w.exited)
+}
+
+// alive reports whether the worker process is still running
+func (w *qWorker) alive() bool {
+	select {
+	case <-w.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// send writes one message to q. Embedded newlines are sent as backslash
+// continuations so multi-line prompts arrive as a single message.
+func (w *qWorker) send(message string) error {
+	message = strings.ReplaceAll(message, "\n", "\\\n")
+	_, err := io.WriteString(w.stdin, message+"\n")
+	return err
+}
+
+// readUntilPrompt consumes output until q prints its input prompt, passing
+// raw output to onData as it arrives, or until ctx is done. A trailing line
+// that could still turn out to be the prompt is held back until it is not.
+func (w *qWorker) readUntilPrompt(ctx context.Context, onData func(data []byte) error) error {
+	var settle <-chan time.Time
+	var pending []byte
+	for {
+		select {
+		case chunk, ok := <-w.output:
+			if !ok {
+				return errWorkerExited
+			}
+			pending = append(pending, chunk...)
+			emit := 0
+			if i := bytes.LastIndexByte(pending, '\n'); i >= 0 {
+				emit = i + 1
+			}
+			complete, _ := splitIncompleteTail(pending[emit:])
+			tail := stripANSI(string(complete))
+			if len(complete) == len(pending[emit:]) && !strings.HasPrefix(poolPromptMarker, tail) {
+				emit = len(pending)
+			}
+			if emit > 0 {
+				if onData != nil {
+					if err := onData(pending[:emit]); err != nil {
+						return err
+					}
+				}
+				pending = append([]byte(nil), pending[emit:]...)
+			}
+
+			settle = nil
+			if stripANSI(string(pending)) == poolPromptMarker {
+				settle = time.After(promptSettle)
+			}
+		case <-settle:
+			return nil
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return errWorkerTimeout
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// run sends a prompt and streams the raw response
+func (w *qWorker) run(ctx context.Context, prompt string, onData func(data []byte) error) error {
+	w.requests++
+	if err := w.send(prompt); err != nil {
+		return err
+	}
+	err := w.readUntilPrompt(ctx, onData)
+	if ctx.Err() != nil {
+		log.Printf("Killing q worker %d: %v", w.cmd.Process.Pid, ctx.Err())
+		return fmt.Errorf("q command cancelled: %w", ctx.Err())
+	}
+	return err
+}
+
+// reset clears the worker's conversation so the next request starts fresh
+func (w *qWorker) reset(command string) error {
+	if command == "" {
+		return nil
+	}
+	if err := w.send(command); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), resetTimeout)
+	defer cancel()
+	return w.readUntilPrompt(ctx, nil)
+}
+
+func (w *qWorker) close() {
+	w.quitOnce.Do(func() {
+		close(w.quit)
+		w.stdin.Close()
+		killProcessGroup(w.cmd)
+	})
+}
+
+// qPool keeps a fixed number of warm q chat workers
+type qPool struct {
+	binary         string
+	size           int
+	maxRequests    int
+	healthInterval time.Duration
+	resetCommand   string
+
+	idle   chan *qWorker
+	live   int32
+	stop   chan struct{}
+	closed sync.Once
+}
+
+func newQPool(size, maxRequests int, healthInterval time.Duration, resetCommand string) *qPool {
+	p := makeQPool(size, maxRequests, healthInterval, resetCommand)
+	p.start()
+	return p
+}
+
+// makeQPool sets up a pool without starting its workers. The q binary is
+// captured here, so start can run in the background.
+func makeQPool(size, maxRequests int, healthInterval time.Duration, resetCommand string) *qPool {
+	return &qPool{
+		binary:         qBinary,
+		size:           size,
+		maxRequests:    maxRequests,
+		healthInterval: healthInterval,
+		resetCommand:   resetCommand,
+		idle:           make(chan *qWorker, size),
+		stop:           make(chan struct{}),
+	}
+}
+
+// start spawns the workers and the loop that keeps them healthy
+func (p *qPool) start() {
+	p.fill()
+	go p.healthLoop(p.healthInterval)
+}
+
+// fill starts workers until the pool is back at its configured size
+func (p *qPool) fill() {
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		n := atomic.LoadInt32(&p.live)
+		if n >= int32(p.size) {
+			return
+		}
+		if !atomic.CompareAndSwapInt32(&p.live, n, n+1) {
+			continue
+		}
+		w, err := startQWorker(p.binary)
+		if err != nil {
+			atomic.AddInt32(&p.live, -1)
+			log.Printf("Failed to start q worker: %v", err)
+			return
+		}
+		p.put(w)
+	}
+}
+
+func (p *qPool) put(w *qWorker) {
+	select {
+	case <-p.stop:
+		p.discard(w)
+	default:
+		p.idle <- w
+	}
+}
+
+func (p *qPool) discard(w *qWorker) {
+	w.close()
+	atomic.AddInt32(&p.live, -1)
+}
+
+// healthLoop replaces idle workers that died and tops the pool back up
+func (p *qPool) healthLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for i := len(p.idle); i > 0; i-- {
+				select {
+				case w := <-p.idle:
+					if w.alive() {
+						p.idle <- w
+					} else {
+						log.Printf("q worker %d exited, replacing", w.cmd.Process.Pid)
+						p.discard(w)
+					}
+				default:
+				}
+			}
+			p.fill()
+		}
+	}
+}
+
+// acquire returns an idle worker, or nil when no worker is available at all
+// or ctx is done first
+func (p *qPool) acquire(ctx context.Context) *qWorker {
+	for {
+		if atomic.LoadInt32(&p.live) == 0 {
+			return nil
+		}
+		select {
+		case w := <-p.idle:
+			if w.alive() {
+				return w
+			}
+			p.discard(w)
+			go p.fill()
+		case <-p.stop:
+			return nil
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// release hands a worker back after a request. Failed or worn-out workers
+// are recycled; healthy ones have their conversation reset first.
+func (p *qPool) release(w *qWorker, failed bool) {
+	go func() {
+		if failed || !w.alive() || (p.maxRequests > 0 && w.requests >= p.maxRequests) {
+			p.discard(w)
+			p.fill()
+			return
+		}
+		if err := w.reset(p.resetCommand); err != nil {
+			log.Printf("Failed to reset q worker: %v", err)
+			p.discard(w)
+			p.fill()
+			return
+		}
+		p.put(w)
+	}()
+}
+
+// Run sends a prompt to a pooled worker. ok is false when the pool had no
+// worker to offer and the caller should fall back to a one-shot process.
+// Cancelling ctx mid-response kills the worker and a fresh one replaces it.
+func (p *qPool) Run(ctx context.Context, prompt string, onData func(data []byte) error) (ok bool, err error) {
+	w := p.acquire(ctx)
+	if w == nil {
+		if ctx.Err() != nil {
+			return true, fmt.Errorf("q command cancelled: %w", ctx.Err())
+		}
+		return false, nil
+	}
+	err = w.run(ctx, prompt, onData)
+	p.release(w, err != nil)
+	return true, err
+}
+
+// Close stops the health loop and terminates idle workers
+func (p *qPool) Close() {
+	p.closed.Do(func() {
+		close(p.stop)
+		for {
+			select {
+			case w := <-p.idle:
+				p.discard(w)
+			default:
+				return
+			}
+		}
+	})
+}
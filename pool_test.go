@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeInteractiveQ mimics an interactive q chat session: it prints a prompt,
+// answers each message with its own pid, and understands /clear and crash.
+// read without -r joins backslash-continued lines like q's line editor.
+const fakeInteractiveQ = `#!/bin/sh
+printf '> '
+while IFS= read line; do
+  case "$line" in
+    /clear) ;;
+    crash) exit 1 ;;
+    *) echo "pid $$"; echo "echo: $line" ;;
+  esac
+  printf '> '
+done
+`
+
+// useFakeQ installs script as the q binary for the duration of a test
+func useFakeQ(t *testing.T, script string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake q scripts need a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "q")
+	require.NoError(t, os.WriteFile(path, []byte(script), 0755))
+
+	previous := qBinary
+	qBinary = path
+	t.Cleanup(func() { qBinary = previous })
+}
+
+// runPool sends prompt through the pool and returns the response lines
+func runPool(t *testing.T, p *qPool, prompt string) []string {
+	var raw strings.Builder
+	ok, err := p.Run(context.Background(), prompt, func(data []byte) error {
+		raw.Write(data)
+		return nil
+	})
+	require.True(t, ok, "pool should have a worker")
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(raw.String(), "\n"), "\n")
+}
+
+// waitIdle waits for released workers to finish resetting
+func waitIdle(t *testing.T, p *qPool, n int) {
+	require.Eventually(t, func() bool { return len(p.idle) == n }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPoolReusesWorker(t *testing.T) {
+	useFakeQ(t, fakeInteractiveQ)
+	p := newQPool(1, 10, time.Hour, "/clear")
+	defer p.Close()
+
+	first := runPool(t, p, "hello")
+	assert.Equal(t, "echo: hello", first[1])
+	waitIdle(t, p, 1)
+
+	second := runPool(t, p, "again")
+	assert.Equal(t, first[0], second[0], "same worker should serve both requests")
+}
+
+func TestPoolRecyclesAfterMaxRequests(t *testing.T) {
+	useFakeQ(t, fakeInteractiveQ)
+	p := newQPool(1, 1, time.Hour, "/clear")
+	defer p.Close()
+
+	first := runPool(t, p, "one")
+	waitIdle(t, p, 1)
+	second := runPool(t, p, "two")
+
+	assert.NotEqual(t, first[0], second[0], "worker should be replaced after one request")
+}
+
+func TestPoolRecoversFromCrash(t *testing.T) {
+	useFakeQ(t, fakeInteractiveQ)
+	p := newQPool(1, 10, time.Hour, "/clear")
+	defer p.Close()
+
+	ok, err := p.Run(context.Background(), "crash", func([]byte) error { return nil })
+	assert.True(t, ok)
+	assert.ErrorIs(t, err, errWorkerExited)
+
+	waitIdle(t, p, 1)
+	lines := runPool(t, p, "still there?")
+	assert.Equal(t, "echo: still there?", lines[1])
+}
+
+func TestPoolMultilinePrompt(t *testing.T) {
+	useFakeQ(t, fakeInteractiveQ)
+	p := newQPool(1, 10, time.Hour, "")
+	defer p.Close()
+
+	lines := runPool(t, p, "line one\nline two")
+	assert.Equal(t, []string{"echo: line oneline two"}, lines[1:])
+}
+
+func TestPoolUnavailableFallsBack(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\nexit 1\n")
+	p := newQPool(1, 10, time.Hour, "/clear")
+	defer p.Close()
+
+	ok, err := p.Run(context.Background(), "hello", func([]byte) error { return nil })
+	assert.False(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestExecuteQCommandUsesPool(t *testing.T) {
+	useFakeQ(t, fakeInteractiveQ)
+	setPool(newQPool(1, 10, time.Hour, "/clear"))
+	defer func() {
+		setPool(nil).Close()
+	}()
+
+	response, err := executeQCommand(context.Background(), "ping", nil)
+	require.NoError(t, err)
+	assert.Contains(t, response, "echo: ping")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// processGone reports whether pid has exited; zombies count as gone
+func processGone(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+// fakeHangingQ starts a long-running child, records its pid and never answers
+func fakeHangingQ(pidFile string) string {
+	return fmt.Sprintf("#!/bin/sh\nsleep 30 &\necho $! > %s\nwait\n", pidFile)
+}
+
+func readPid(t *testing.T, pidFile string) int {
+	var pid int
+	require.Eventually(t, func() bool {
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return pid
+}
+
+func TestExecuteQCommandTimeoutKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	useFakeQ(t, fakeHangingQ(pidFile))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := executeQCommand(ctx, "hello", nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	child := readPid(t, pidFile)
+	assert.Eventually(t, func() bool { return processGone(child) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStreamCancelKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	useFakeQ(t, fakeHangingQ(pidFile))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- (&QCLIBackend{}).Stream(ctx, QRequest{Prompt: "hello"}, func(string) error { return nil })
+	}()
+
+	child := readPid(t, pidFile)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not stop after cancellation")
+	}
+	assert.Eventually(t, func() bool { return processGone(child) }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestPoolCancelReplacesWorker(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\nprintf '> '\nwhile IFS= read line; do\n  [ \"$line\" = slow ] && sleep 30\n  echo \"pid $$\"\n  printf '> '\ndone\n")
+	p := newQPool(1, 10, time.Hour, "")
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	ok, err := p.Run(ctx, "slow", func([]byte) error { return nil })
+	assert.True(t, ok)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "unexpected error: %v", err)
+
+	waitIdle(t, p, 1)
+	lines := runPool(t, p, "fast")
+	assert.Contains(t, lines[0], "pid ")
+}
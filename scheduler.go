@@ -0,0 +1,131 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errQueueFull    = errors.New("server busy, request queue is full")
+	errQueueTimeout = errors.New("timed out waiting in request queue")
+)
+
+// retryAfterSeconds is sent in Retry-After when a request is turned away
+const retryAfterSeconds = "5"
+
+// admission limits how many q invocations run at once
+var admission = newScheduler(config.NumParallel, config.MaxQueue, config.QueueTimeout)
+
+// QueueStatus describes the admission queue for /api/ps
+type QueueStatus struct {
+	Active   int `json:"active"`
+	Queued   int `json:"queued"`
+	Parallel int `json:"parallel"`
+	MaxQueue int `json:"max_queue"`
+}
+
+// scheduler is a counting semaphore with a bounded FIFO wait queue
+type scheduler struct {
+	mu       sync.Mutex
+	parallel int
+	maxQueue int
+	timeout  time.Duration
+	active   int
+	waiting  *list.List
+}
+
+func newScheduler(parallel, maxQueue int, timeout time.Duration) *scheduler {
+	if parallel < 1 {
+		parallel = 1
+	}
+	return &scheduler{
+		parallel: parallel,
+		maxQueue: maxQueue,
+		timeout:  timeout,
+		waiting:  list.New(),
+	}
+}
+
+// Acquire waits for an execution slot in arrival order. The returned release
+// function must be called once the q invocation has finished.
+func (s *scheduler) Acquire(ctx context.Context) (release func(), err error) {
+	s.mu.Lock()
+	if s.active < s.parallel && s.waiting.Len() == 0 {
+		s.active++
+		s.mu.Unlock()
+		return s.releaseFunc(), nil
+	}
+	if s.waiting.Len() >= s.maxQueue {
+		s.mu.Unlock()
+		return nil, errQueueFull
+	}
+	ready := make(chan struct{})
+	elem := s.waiting.PushBack(ready)
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ready:
+		return s.releaseFunc(), nil
+	case <-ctx.Done():
+		// A request that runs out of time or is cancelled in the queue
+		// fails the way it would have while q was running
+		err = classifyQError(ctx, ctx.Err(), "")
+	case <-timeout:
+		err = errQueueTimeout
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-ready:
+		// The slot was handed over while we were giving up; pass it on
+		s.handOff()
+	default:
+		s.waiting.Remove(elem)
+	}
+	return nil, err
+}
+
+func (s *scheduler) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.handOff()
+		})
+	}
+}
+
+// handOff gives a finished slot to the oldest waiter, or frees it.
+// Callers must hold s.mu.
+func (s *scheduler) handOff() {
+	if front := s.waiting.Front(); front != nil {
+		s.waiting.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	s.active--
+}
+
+// Status reports current slot usage and queue depth
+func (s *scheduler) Status() QueueStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return QueueStatus{
+		Active:   s.active,
+		Queued:   s.waiting.Len(),
+		Parallel: s.parallel,
+		MaxQueue: s.maxQueue,
+	}
+}
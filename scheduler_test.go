@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useScheduler swaps the admission scheduler for the duration of a test
+func useScheduler(t *testing.T, s *scheduler) {
+	previous := admission
+	admission = s
+	t.Cleanup(func() { admission = previous })
+}
+
+func TestSchedulerLimitsParallelism(t *testing.T) {
+	s := newScheduler(2, 10, time.Second)
+
+	r1, err := s.Acquire(context.Background())
+	require.NoError(t, err)
+	r2, err := s.Acquire(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Status().Active)
+
+	acquired := make(chan struct{})
+	go func() {
+		release, err := s.Acquire(context.Background())
+		if err == nil {
+			close(acquired)
+			release()
+		}
+	}()
+
+	require.Eventually(t, func() bool { return s.Status().Queued == 1 }, time.Second, time.Millisecond)
+	r1()
+	<-acquired
+	r2()
+
+	assert.Equal(t, QueueStatus{Parallel: 2, MaxQueue: 10}, s.Status())
+}
+
+func TestSchedulerIsFIFO(t *testing.T) {
+	s := newScheduler(1, 10, time.Second)
+	hold, err := s.Acquire(context.Background())
+	require.NoError(t, err)
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func(i int) {
+			release, err := s.Acquire(context.Background())
+			if err == nil {
+				order <- i
+				release()
+			}
+		}(i)
+		require.Eventually(t, func() bool { return s.Status().Queued == i+1 }, time.Second, time.Millisecond)
+	}
+
+	hold()
+	assert.Equal(t, 0, <-order)
+	assert.Equal(t, 1, <-order)
+	assert.Equal(t, 2, <-order)
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	s := newScheduler(1, 0, time.Second)
+	release, err := s.Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	_, err = s.Acquire(context.Background())
+	assert.ErrorIs(t, err, errQueueFull)
+}
+
+func TestSchedulerQueueTimeout(t *testing.T) {
+	s := newScheduler(1, 1, 20*time.Millisecond)
+	release, err := s.Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	_, err = s.Acquire(context.Background())
+	assert.ErrorIs(t, err, errQueueTimeout)
+	assert.Equal(t, 0, s.Status().Queued)
+}
+
+func TestSchedulerCancelledWhileQueued(t *testing.T) {
+	s := newScheduler(1, 1, time.Minute)
+	release, err := s.Acquire(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		for s.Status().Queued == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	_, err = s.Acquire(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	status, code := errorStatus(err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, string(QErrKilled), code)
+
+	release()
+	assert.Equal(t, 0, s.Status().Active)
+}
+
+func TestRequestTimeoutWhileQueuedReturns504(t *testing.T) {
+	previous := config.RequestTimeout
+	config.RequestTimeout = 20 * time.Millisecond
+	t.Cleanup(func() { config.RequestTimeout = previous })
+	useBackend(t, &QCLIBackend{})
+	s := newScheduler(1, 1, time.Minute)
+	useScheduler(t, s)
+	release, err := s.Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "hi", Stream: boolPtr(false)})
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"timeout"`)
+	assert.Equal(t, 0, s.Status().Queued)
+}
+
+func TestQueueFullReturns503(t *testing.T) {
+	useBackend(t, &fakeBackend{err: errQueueFull})
+
+	w := postJSON(t, "/api/chat", ChatRequest{
+		Model:    "amazon-q",
+		Messages: []Message{{Role: "user", Content: "hi"}},
+	})
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, retryAfterSeconds, w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "queue is full")
+}
+
+func TestQueueDepthReported(t *testing.T) {
+	s := newScheduler(1, 5, time.Second)
+	useScheduler(t, s)
+	release, err := s.Acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/ps", nil)
+	setupRouter().ServeHTTP(w, req)
+
+	var ps PsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &ps))
+	require.NotNil(t, ps.Queue)
+	assert.Equal(t, QueueStatus{Active: 1, Parallel: 1, MaxQueue: 5}, *ps.Queue)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	setupRouter().ServeHTTP(w, req)
+
+	assert.Contains(t, w.Body.String(), "amazon_q_ollama_requests_active 1\n")
+	assert.Contains(t, w.Body.String(), "amazon_q_ollama_queue_depth 0\n")
+}
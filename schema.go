@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is the subset of JSON Schema that structured outputs are checked
+// against: type, properties, required, additionalProperties, items, enum,
+// const, anyOf, length and range bounds, and local $ref into $defs.
+// Annotations such as title and description are accepted and ignored.
+type Schema struct {
+	Type                 schemaTypes        `json:"type"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties *additionalSchema  `json:"additionalProperties"`
+	Items                *Schema            `json:"items"`
+	Enum                 []interface{}      `json:"enum"`
+	Const                *interface{}       `json:"const"`
+	AnyOf                []*Schema          `json:"anyOf"`
+	MinLength            *int               `json:"minLength"`
+	MaxLength            *int               `json:"maxLength"`
+	Minimum              *float64           `json:"minimum"`
+	Maximum              *float64           `json:"maximum"`
+	MinItems             *int               `json:"minItems"`
+	MaxItems             *int               `json:"maxItems"`
+	Ref                  string             `json:"$ref"`
+	Defs                 map[string]*Schema `json:"$defs"`
+	Definitions          map[string]*Schema `json:"definitions"`
+}
+
+// schemaTypes is the type keyword: one type name or a list of them
+type schemaTypes []string
+
+// additionalSchema is additionalProperties: a boolean or a schema
+type additionalSchema struct {
+	Forbidden bool
+	Schema    *Schema
+}
+
+// SchemaViolation is one way a value fails its schema. Path locates the
+// value, e.g. $.items[2].name.
+type SchemaViolation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// schemaMismatchError reports the violations of a response that is valid
+// JSON but does not match the schema
+type schemaMismatchError struct {
+	Violations []SchemaViolation
+}
+
+func (e *schemaMismatchError) Error() string {
+	parts := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		parts = append(parts, v.Path+": "+v.Message)
+	}
+	return "the JSON does not match the schema: " + strings.Join(parts, "; ")
+}
+
+var schemaTypeNames = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+	} else if err := json.Unmarshal(data, (*[]string)(t)); err != nil {
+		return errors.New("type must be a string or an array of strings")
+	}
+	for _, name := range *t {
+		if !schemaTypeNames[name] {
+			return fmt.Errorf("unknown type %q", name)
+		}
+	}
+	return nil
+}
+
+func (a *additionalSchema) UnmarshalJSON(data []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err == nil {
+		a.Forbidden = !allowed
+		return nil
+	}
+	a.Schema = &Schema{}
+	return json.Unmarshal(data, a.Schema)
+}
+
+// parseSchema reads a JSON Schema and checks that its references resolve
+func parseSchema(data []byte) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	if err := schema.checkRefs(&schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func (s *Schema) checkRefs(root *Schema) error {
+	if s == nil {
+		return nil
+	}
+	// Items and additionalProperties may be null, but a null property,
+	// definition or anyOf entry is not a schema
+	for _, group := range []map[string]*Schema{s.Properties, s.Defs, s.Definitions} {
+		for name, child := range group {
+			if child == nil {
+				return fmt.Errorf("%q must be a schema object, not null", name)
+			}
+		}
+	}
+	for _, child := range s.AnyOf {
+		if child == nil {
+			return errors.New("anyOf entries must be schema objects, not null")
+		}
+	}
+	if s.Ref != "" {
+		if err := root.checkRefChain(s); err != nil {
+			return err
+		}
+	}
+	children := []*Schema{s.Items}
+	if s.AdditionalProperties != nil {
+		children = append(children, s.AdditionalProperties.Schema)
+	}
+	children = append(children, s.AnyOf...)
+	for _, group := range []map[string]*Schema{s.Properties, s.Defs, s.Definitions} {
+		for _, child := range group {
+			children = append(children, child)
+		}
+	}
+	for _, child := range children {
+		if err := child.checkRefs(root); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRefChain follows from through references to other references and
+// fails if they lead back to one already followed instead of to a schema
+func (s *Schema) checkRefChain(from *Schema) error {
+	seen := map[*Schema]bool{}
+	for from.Ref != "" {
+		if seen[from] {
+			return fmt.Errorf("$ref %q refers back to itself", from.Ref)
+		}
+		seen[from] = true
+		target, err := s.resolve(from.Ref)
+		if err != nil {
+			return err
+		}
+		from = target
+	}
+	return nil
+}
+
+// refVisit is a reference target being expanded at a value path. Meeting it
+// again means the schema loops without descending into the value.
+type refVisit struct {
+	target *Schema
+	path   string
+}
+
+// resolve looks up a local reference such as #/$defs/Address
+func (s *Schema) resolve(ref string) (*Schema, error) {
+	var defs map[string]*Schema
+	var name string
+	switch {
+	case strings.HasPrefix(ref, "#/$defs/"):
+		defs, name = s.Defs, strings.TrimPrefix(ref, "#/$defs/")
+	case strings.HasPrefix(ref, "#/definitions/"):
+		defs, name = s.Definitions, strings.TrimPrefix(ref, "#/definitions/")
+	case ref == "#":
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported $ref %q; only local references are supported", ref)
+	}
+	if target, ok := defs[name]; ok {
+		return target, nil
+	}
+	return nil, fmt.Errorf("$ref %q does not resolve", ref)
+}
+
+// Validate checks a JSON document against the schema
+func (s *Schema) Validate(document string) []SchemaViolation {
+	var value interface{}
+	if err := json.Unmarshal([]byte(document), &value); err != nil {
+		return []SchemaViolation{{Path: "$", Message: err.Error()}}
+	}
+	var violations []SchemaViolation
+	s.validate(s, value, "$", &violations, map[refVisit]bool{})
+	return violations
+}
+
+func (s *Schema) validate(root *Schema, value interface{}, path string, out *[]SchemaViolation, expanding map[refVisit]bool) {
+	fail := func(format string, args ...interface{}) {
+		*out = append(*out, SchemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.Ref != "" {
+		// References were checked when the schema was parsed
+		target, _ := root.resolve(s.Ref)
+		visit := refVisit{target, path}
+		if expanding[visit] {
+			fail("$ref %q refers back to itself", s.Ref)
+			return
+		}
+		expanding[visit] = true
+		target.validate(root, value, path, out, expanding)
+		delete(expanding, visit)
+		return
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(value) {
+		fail("expected %s, got %s", strings.Join(s.Type, " or "), jsonTypeName(value))
+		return
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, value) {
+		allowed, _ := json.Marshal(s.Enum)
+		fail("must be one of %s", allowed)
+	}
+	if s.Const != nil && !reflect.DeepEqual(*s.Const, value) {
+		expected, _ := json.Marshal(*s.Const)
+		fail("must be %s", expected)
+	}
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, option := range s.AnyOf {
+			var discard []SchemaViolation
+			option.validate(root, value, path, &discard, expanding)
+			if len(discard) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any of the allowed schemas")
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(v)
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	case []interface{}:
+		if s.MinItems != nil && len(v) < *s.MinItems {
+			fail("must have at least %d items", *s.MinItems)
+		}
+		if s.MaxItems != nil && len(v) > *s.MaxItems {
+			fail("must have at most %d items", *s.MaxItems)
+		}
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(root, item, fmt.Sprintf("%s[%d]", path, i), out, expanding)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*out = append(*out, SchemaViolation{Path: path + "." + name, Message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childPath := path + "." + name
+			if property, ok := s.Properties[name]; ok {
+				property.validate(root, v[name], childPath, out, expanding)
+			} else if s.AdditionalProperties != nil {
+				if s.AdditionalProperties.Forbidden {
+					*out = append(*out, SchemaViolation{Path: childPath, Message: "is not allowed"})
+				} else if s.AdditionalProperties.Schema != nil {
+					s.AdditionalProperties.Schema.validate(root, v[name], childPath, out, expanding)
+				}
+			}
+		}
+	}
+}
+
+func (t schemaTypes) matches(value interface{}) bool {
+	for _, name := range t {
+		switch name {
+		case "integer":
+			if n, ok := value.(float64); ok && n == math.Trunc(n) {
+				return true
+			}
+		case "number":
+			if _, ok := value.(float64); ok {
+				return true
+			}
+		default:
+			if jsonTypeName(value) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonTypeName names the JSON type of a decoded value
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema, err := parseSchema([]byte(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+			"address": {"$ref": "#/$defs/Address"},
+			"nickname": {"anyOf": [{"type": "string"}, {"type": "null"}]}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"$defs": {
+			"Address": {
+				"type": "object",
+				"properties": {"city": {"type": "string"}},
+				"required": ["city"]
+			}
+		}
+	}`))
+	require.NoError(t, err)
+
+	assert.Empty(t, schema.Validate(`{"name": "Ada", "age": 36, "role": "admin", "tags": ["math"], "address": {"city": "London"}, "nickname": null}`))
+
+	cases := []struct {
+		name     string
+		document string
+		want     []SchemaViolation
+	}{
+		{"wrong root type", `[]`, []SchemaViolation{{"$", "expected object, got array"}}},
+		{"missing required", `{"name": "Ada"}`, []SchemaViolation{{"$.age", "is required"}}},
+		{"integer", `{"name": "Ada", "age": 36.5}`, []SchemaViolation{{"$.age", "expected integer, got number"}}},
+		{"minimum", `{"name": "Ada", "age": -1}`, []SchemaViolation{{"$.age", "must be at least 0"}}},
+		{"min length", `{"name": "", "age": 1}`, []SchemaViolation{{"$.name", "must be at least 1 characters long"}}},
+		{"enum", `{"name": "Ada", "age": 1, "role": "root"}`, []SchemaViolation{{"$.role", `must be one of ["admin","user"]`}}},
+		{"array items", `{"name": "Ada", "age": 1, "tags": ["a", 2]}`, []SchemaViolation{{"$.tags[1]", "expected string, got number"}}},
+		{"max items", `{"name": "Ada", "age": 1, "tags": ["a", "b", "c"]}`, []SchemaViolation{{"$.tags", "must have at most 2 items"}}},
+		{"nested ref", `{"name": "Ada", "age": 1, "address": {}}`, []SchemaViolation{{"$.address.city", "is required"}}},
+		{"any of", `{"name": "Ada", "age": 1, "nickname": 3}`, []SchemaViolation{{"$.nickname", "does not match any of the allowed schemas"}}},
+		{"additional property", `{"name": "Ada", "age": 1, "extra": true}`, []SchemaViolation{{"$.extra", "is not allowed"}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, schema.Validate(tc.document))
+		})
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	for _, raw := range []string{
+		`{"type": "text"}`,
+		`{"type": 3}`,
+		`{"properties": {"a": {"$ref": "#/$defs/Missing"}}}`,
+		`{"$ref": "https://example.com/schema.json"}`,
+		`"json"`,
+	} {
+		_, err := parseSchema([]byte(raw))
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestSchemaNullSubschemas(t *testing.T) {
+	for _, raw := range []string{
+		`{"$ref": "#/$defs/a", "$defs": {"a": null}}`,
+		`{"properties": {"a": null}}`,
+		`{"anyOf": [null]}`,
+	} {
+		_, err := parseSchema([]byte(raw))
+		assert.ErrorContains(t, err, "not null", raw)
+	}
+
+	// A null items or additionalProperties just leaves the keyword out
+	schema, err := parseSchema([]byte(`{"items": null, "additionalProperties": null}`))
+	require.NoError(t, err)
+	assert.Empty(t, schema.Validate(`[1]`))
+	assert.Empty(t, schema.Validate(`{"a": 1}`))
+
+	useBackend(t, &fakeBackend{response: "unused"})
+	w := postJSON(t, "/api/generate", map[string]interface{}{"model": "amazon-q", "prompt": "hi", "format": map[string]interface{}{"anyOf": []interface{}{nil}}})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid format schema")
+}
+
+func TestSchemaRefCycles(t *testing.T) {
+	for _, raw := range []string{
+		`{"$ref": "#"}`,
+		`{"$ref": "#/$defs/a", "$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"$ref": "#/$defs/a"}}}`,
+	} {
+		_, err := parseSchema([]byte(raw))
+		assert.ErrorContains(t, err, "refers back to itself", raw)
+	}
+
+	// A loop through anyOf only shows once a value is checked
+	schema, err := parseSchema([]byte(`{"anyOf": [{"$ref": "#"}, {"type": "string"}]}`))
+	require.NoError(t, err)
+	assert.Empty(t, schema.Validate(`"ok"`))
+	assert.Equal(t, []SchemaViolation{{"$", "does not match any of the allowed schemas"}}, schema.Validate(`{}`))
+
+	// Recursion that descends into the value is fine
+	schema, err = parseSchema([]byte(`{"type": "object", "properties": {"child": {"$ref": "#"}}, "additionalProperties": false}`))
+	require.NoError(t, err)
+	assert.Equal(t, []SchemaViolation{{"$.child.child.x", "is not allowed"}}, schema.Validate(`{"child": {"child": {"x": 1}}}`))
+}
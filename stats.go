@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reasons reported in done_reason on the final response
+const (
+	doneStop      = "stop"
+	doneLength    = "length"
+	doneCancelled = "cancelled"
+	doneError     = "error"
+	// doneLoad and doneUnload answer empty requests that only preload or
+	// unload the model
+	doneLoad   = "load"
+	doneUnload = "unload"
+)
+
+// requestStats times one request so the final response can carry Ollama's
+// metric fields. The total splits into load (queueing and q start-up),
+// prompt evaluation (until the first output) and evaluation (the rest).
+type requestStats struct {
+	mu           sync.Mutex
+	start        time.Time
+	loaded       time.Time
+	firstOutput  time.Time
+	promptTokens int
+	truncated    bool
+	stopSequence string
+}
+
+type requestStatsKey struct{}
+
+// newRequestStats starts timing a request for prompt and attaches the stats
+// to ctx so the backend can report when q is ready
+func newRequestStats(ctx context.Context, prompt string) (context.Context, *requestStats) {
+	s := &requestStats{start: time.Now(), promptTokens: countTokens(prompt)}
+	return context.WithValue(ctx, requestStatsKey{}, s), s
+}
+
+// markLoaded records that the request has left the queue and q is running
+func markLoaded(ctx context.Context) {
+	if s, ok := ctx.Value(requestStatsKey{}).(*requestStats); ok {
+		s.mu.Lock()
+		s.loaded = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+// markOutput records that q produced its first output
+func markOutput(ctx context.Context) {
+	if s, ok := ctx.Value(requestStatsKey{}).(*requestStats); ok {
+		s.mu.Lock()
+		if s.firstOutput.IsZero() {
+			s.firstOutput = time.Now()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// markTruncated records that the output was cut short at num_predict
+func markTruncated(ctx context.Context) {
+	if s, ok := ctx.Value(requestStatsKey{}).(*requestStats); ok {
+		s.mu.Lock()
+		s.truncated = true
+		s.mu.Unlock()
+	}
+}
+
+// markStopSequence records that the output ended at the stop sequence stop
+func markStopSequence(ctx context.Context, stop string) {
+	if s, ok := ctx.Value(requestStatsKey{}).(*requestStats); ok {
+		s.mu.Lock()
+		s.stopSequence = stop
+		s.mu.Unlock()
+	}
+}
+
+// outputMarker calls markOutput on the first write that passes through it
+type outputMarker struct {
+	ctx    context.Context
+	out    io.Writer
+	marked bool
+}
+
+func (m *outputMarker) Write(p []byte) (int, error) {
+	if !m.marked && len(p) > 0 {
+		m.marked = true
+		markOutput(m.ctx)
+	}
+	return m.out.Write(p)
+}
+
+// responseMetrics are the metric fields of a final generate or chat response
+type responseMetrics struct {
+	TotalDuration      int64
+	LoadDuration       int64
+	PromptEvalCount    int
+	PromptEvalDuration int64
+	EvalCount          int
+	EvalDuration       int64
+	DoneReason         string
+	// StopSequence is the stop sequence that ended a "stop" response, if any
+	StopSequence string
+}
+
+// finish stops the clock and computes the metrics for response. A response
+// that otherwise ended normally is reported as "length" when it was
+// truncated.
+func (s *requestStats) finish(response, reason string) responseMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reason == doneStop && s.truncated {
+		reason = doneLength
+	}
+	stopSequence := ""
+	if reason == doneStop {
+		stopSequence = s.stopSequence
+	}
+	end := time.Now()
+	loaded := s.loaded
+	if loaded.IsZero() {
+		loaded = s.start
+	}
+	first := s.firstOutput
+	if first.IsZero() || first.Before(loaded) {
+		first = loaded
+	}
+	return responseMetrics{
+		TotalDuration:      end.Sub(s.start).Nanoseconds(),
+		LoadDuration:       loaded.Sub(s.start).Nanoseconds(),
+		PromptEvalCount:    s.promptTokens,
+		PromptEvalDuration: first.Sub(loaded).Nanoseconds(),
+		EvalCount:          countTokens(response),
+		EvalDuration:       end.Sub(first).Nanoseconds(),
+		DoneReason:         reason,
+		StopSequence:       stopSequence,
+	}
+}
+
+func (m responseMetrics) applyGenerate(r *GenerateResponse) {
+	r.TotalDuration = m.TotalDuration
+	r.LoadDuration = m.LoadDuration
+	r.PromptEvalCount = m.PromptEvalCount
+	r.PromptEvalDuration = m.PromptEvalDuration
+	r.EvalCount = m.EvalCount
+	r.EvalDuration = m.EvalDuration
+	r.DoneReason = m.DoneReason
+}
+
+func (m responseMetrics) applyChat(r *ChatResponse) {
+	r.TotalDuration = m.TotalDuration
+	r.LoadDuration = m.LoadDuration
+	r.PromptEvalCount = m.PromptEvalCount
+	r.PromptEvalDuration = m.PromptEvalDuration
+	r.EvalCount = m.EvalCount
+	r.EvalDuration = m.EvalDuration
+	r.DoneReason = m.DoneReason
+}
+
+// countTokens estimates the token count of text. q does not report usage,
+// so prompts and responses are both counted as whitespace-separated words.
+func countTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+// doneReason maps how a request ended onto done_reason
+func doneReason(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return doneStop
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return doneCancelled
+	default:
+		return doneError
+	}
+}
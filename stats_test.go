@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// assertMetrics checks that a final frame carries a complete, consistent set of metrics
+func assertMetrics(t *testing.T, frame map[string]interface{}, promptTokens, evalTokens int) {
+	for _, key := range []string{"total_duration", "load_duration", "prompt_eval_duration", "eval_duration"} {
+		assert.Contains(t, frame, key)
+	}
+	assert.EqualValues(t, promptTokens, frame["prompt_eval_count"])
+	assert.EqualValues(t, evalTokens, frame["eval_count"])
+
+	total, _ := frame["total_duration"].(float64)
+	load, _ := frame["load_duration"].(float64)
+	promptEval, _ := frame["prompt_eval_duration"].(float64)
+	eval, _ := frame["eval_duration"].(float64)
+	assert.GreaterOrEqual(t, load, float64(time.Millisecond))
+	assert.Equal(t, total, load+promptEval+eval)
+}
+
+func TestStreamingGenerateFinalFrameMetrics(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"one two ", "three"}, delay: time.Millisecond})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "count to three", Raw: true, Stream: boolPtr(true)})
+
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 3)
+	final := frames[2]
+	assert.Equal(t, true, final["done"])
+	assert.Equal(t, "stop", final["done_reason"])
+	assertMetrics(t, final, 3, 3)
+	assert.NotContains(t, frames[0], "done_reason")
+}
+
+func TestStreamingChatFinalFrameMetrics(t *testing.T) {
+	useBackend(t, &fakeBackend{chunks: []string{"Paris"}, delay: time.Millisecond})
+
+	w := postJSON(t, "/api/chat", ChatRequest{
+		Model:    "amazon-q",
+		Messages: []Message{{Role: "user", Content: "Capital of France?"}},
+		Stream:   boolPtr(true),
+	})
+
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 2)
+	assert.Equal(t, "stop", frames[1]["done_reason"])
+	assertMetrics(t, frames[1], 3, 1)
+}
+
+func TestNonStreamingResponsesCarryMetrics(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "Hello from Q", delay: time.Millisecond})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "say hello", Raw: true, Stream: boolPtr(false)})
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 1)
+	assert.Equal(t, "stop", frames[0]["done_reason"])
+	assertMetrics(t, frames[0], 2, 3)
+
+	w = postJSON(t, "/api/chat", ChatRequest{Model: "amazon-q", Messages: []Message{{Role: "user", Content: "say hello"}}, Stream: boolPtr(false)})
+	frames = readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 1)
+	assert.Equal(t, "stop", frames[0]["done_reason"])
+	assertMetrics(t, frames[0], 2, 3)
+}
+
+func TestStreamingDoneReasonOnFailure(t *testing.T) {
+	cases := []struct {
+		err    error
+		reason string
+	}{
+		{errors.New("q crashed"), "error"},
+		{&QError{Kind: QErrTimeout, Message: "timed out", Err: context.DeadlineExceeded}, "error"},
+		{&QError{Kind: QErrKilled, Message: "request cancelled", Err: context.Canceled}, "cancelled"},
+	}
+	for _, tc := range cases {
+		useBackend(t, &fakeBackend{chunks: []string{"partial"}, err: tc.err})
+
+		w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", Prompt: "hi", Stream: boolPtr(true)})
+
+		frames := readNDJSON(t, w.Body.String())
+		require.NotEmpty(t, frames)
+		assert.Equal(t, tc.reason, frames[len(frames)-1]["done_reason"], tc.err.Error())
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxEscapeLen bounds how long an unterminated escape sequence is held back
+// before it is treated as stray bytes
+const maxEscapeLen = 256
+
+// splitIncompleteTail separates a trailing partial escape sequence or partial
+// UTF-8 rune from data, so neither is ever split across two chunks
+func splitIncompleteTail(data []byte) (complete, tail []byte) {
+	for i := max(0, len(data)-maxEscapeLen); i < len(data); i++ {
+		if data[i] == 0x1b && !escapeComplete(data[i:]) {
+			return data[:i], data[i:]
+		}
+	}
+	for n := 1; n < utf8.UTFMax && n <= len(data); n++ {
+		start := len(data) - n
+		if utf8.RuneStart(data[start]) {
+			if !utf8.FullRune(data[start:]) {
+				return data[:start], data[start:]
+			}
+			break
+		}
+	}
+	return data, nil
+}
+
+// escapeComplete reports whether the escape sequence at the start of seq is
+// terminated, using the same grammar as ansiPattern
+func escapeComplete(seq []byte) bool {
+	if len(seq) < 2 {
+		return false
+	}
+	switch seq[1] {
+	case '[':
+		for _, b := range seq[2:] {
+			// A final byte ends the sequence; anything outside the CSI
+			// range means it was never a sequence at all
+			if b >= 0x40 || b < 0x20 {
+				return true
+			}
+		}
+		return false
+	case ']':
+		for i, b := range seq[2:] {
+			if b == 0x07 || (b == 0x1b && i+3 < len(seq) && seq[i+3] == '\\') {
+				return true
+			}
+		}
+		return false
+	case '(', ')':
+		return len(seq) > 2
+	default:
+		return true
+	}
+}
+
+// streamCleaner incrementally turns raw q output into assistant text. Until
+// the answer starts, output is handled line by line so banners and spinners
+// can be dropped; after that, bytes pass through as soon as they are safe to
+// emit, with newlines and whitespace preserved.
+type streamCleaner struct {
+	parser   *outputParser
+	started  bool
+	pending  []byte
+	newlines int
+}
+
+func newStreamCleaner() *streamCleaner {
+	return &streamCleaner{parser: newOutputParser()}
+}
+
+// Write consumes raw output and returns the text that is ready to emit
+func (c *streamCleaner) Write(p []byte) string {
+	c.pending = append(c.pending, p...)
+	if !c.started {
+		return c.beforeAnswer()
+	}
+	return c.drain(false)
+}
+
+// Flush returns whatever is still buffered at the end of the output.
+// Trailing newlines are dropped, matching the buffered response.
+func (c *streamCleaner) Flush() string {
+	if !c.started {
+		line := string(c.pending)
+		c.pending = nil
+		if text, ok := c.parser.ParseLine(line); ok {
+			return strings.TrimRight(text, "\n")
+		}
+		return ""
+	}
+	return c.drain(true)
+}
+
+// beforeAnswer drops chrome lines until the first line of the answer appears
+func (c *streamCleaner) beforeAnswer() string {
+	for {
+		i := bytes.IndexByte(c.pending, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(c.pending[:i])
+		c.pending = c.pending[i+1:]
+		if text, ok := c.parser.ParseLine(line); ok {
+			c.started = true
+			c.pending = append([]byte(text+"\n"), c.pending...)
+			return c.drain(false)
+		}
+	}
+
+	// A partial line that can no longer turn into chrome starts the answer
+	// without waiting for its newline
+	complete, _ := splitIncompleteTail(c.pending)
+	visible := stripANSI(string(complete))
+	if !startsAnswer(visible) {
+		return ""
+	}
+	text, _ := c.parser.ParseLine(visible)
+	c.started = true
+	c.pending = append([]byte(text), c.pending[len(complete):]...)
+	return c.drain(false)
+}
+
+// startsAnswer reports whether an unfinished line is already known to be
+// answer text rather than a spinner or banner that is still being printed
+func startsAnswer(partial string) bool {
+	trimmed := strings.TrimSpace(partial)
+	if trimmed == "" || trimmed == ">" || strings.ContainsRune(partial, '\r') {
+		return false
+	}
+	if r, _ := utf8.DecodeRuneInString(trimmed); isSpinnerLine(trimmed) || strings.ContainsRune("|/-\\", r) {
+		return false
+	}
+	for _, pattern := range chromePatterns {
+		prefix, _ := pattern.LiteralPrefix()
+		if strings.HasPrefix(prefix, trimmed) || pattern.MatchString(trimmed) {
+			return false
+		}
+	}
+	return true
+}
+
+// drain emits buffered answer text, holding back incomplete sequences and
+// trailing newlines until more output arrives
+func (c *streamCleaner) drain(final bool) string {
+	var ready []byte
+	if final {
+		ready, c.pending = c.pending, nil
+	} else {
+		ready, c.pending = splitIncompleteTail(c.pending)
+		if n := len(ready); n > 0 && ready[n-1] == '\r' {
+			ready, c.pending = ready[:n-1], append([]byte{'\r'}, c.pending...)
+		}
+		c.pending = append([]byte(nil), c.pending...)
+	}
+
+	text := stripANSI(string(ready))
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "")
+	if final {
+		c.newlines = 0
+		return strings.TrimRight(text, "\n")
+	}
+
+	body := strings.TrimRight(text, "\n")
+	if body == "" {
+		c.newlines += len(text)
+		return ""
+	}
+	out := strings.Repeat("\n", c.newlines) + body
+	c.newlines = len(text) - len(body)
+	return out
+}
+
+// chunkStreamer batches cleaned output and hands it to onChunk once
+// flushSize bytes are buffered or flushInterval has passed
+type chunkStreamer struct {
+	mu       sync.Mutex
+	cleaner  *streamCleaner
+	buf      strings.Builder
+	size     int
+	interval time.Duration
+	timer    *time.Timer
+	onChunk  func(chunk string) error
+	err      error
+	closed   bool
+}
+
+func newChunkStreamer(onChunk func(chunk string) error) *chunkStreamer {
+	return &chunkStreamer{
+		cleaner:  newStreamCleaner(),
+		size:     config.StreamFlushSize,
+		interval: config.StreamFlushInterval,
+		onChunk:  onChunk,
+	}
+}
+
+// Write implements io.Writer so the streamer can be a command's stdout
+func (s *chunkStreamer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	s.buf.WriteString(s.cleaner.Write(p))
+	if s.interval <= 0 || s.buf.Len() >= s.size {
+		s.flushLocked()
+	} else if s.timer == nil && s.buf.Len() > 0 {
+		s.timer = time.AfterFunc(s.interval, s.flushTimer)
+	}
+	return len(p), s.err
+}
+
+func (s *chunkStreamer) flushTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.timer = nil
+	if !s.closed {
+		s.flushLocked()
+	}
+}
+
+func (s *chunkStreamer) flushLocked() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if s.buf.Len() == 0 || s.err != nil {
+		return
+	}
+	chunk := s.buf.String()
+	s.buf.Reset()
+	s.err = s.onChunk(chunk)
+}
+
+// Close flushes everything that is left and reports any onChunk failure
+func (s *chunkStreamer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.buf.WriteString(s.cleaner.Flush())
+		s.flushLocked()
+	}
+	return s.err
+}
+
+// Err reports the first onChunk failure, if any
+func (s *chunkStreamer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
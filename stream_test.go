@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feedBytes streams raw through a cleaner one byte at a time
+func feedBytes(raw string) []string {
+	cleaner := newStreamCleaner()
+	var out []string
+	for i := 0; i < len(raw); i++ {
+		if text := cleaner.Write([]byte{raw[i]}); text != "" {
+			out = append(out, text)
+		}
+	}
+	if text := cleaner.Flush(); text != "" {
+		out = append(out, text)
+	}
+	return out
+}
+
+// TestStreamCleanerFixtures checks that streaming a capture byte by byte
+// yields the same text as cleaning it in one go
+func TestStreamCleanerFixtures(t *testing.T) {
+	raws, err := filepath.Glob(filepath.Join("testdata", "qoutput", "*.raw"))
+	require.NoError(t, err)
+
+	for _, rawPath := range raws {
+		name := strings.TrimSuffix(filepath.Base(rawPath), ".raw")
+		t.Run(name, func(t *testing.T) {
+			raw, err := os.ReadFile(rawPath)
+			require.NoError(t, err)
+			golden, err := os.ReadFile(strings.TrimSuffix(rawPath, ".raw") + ".golden")
+			require.NoError(t, err)
+
+			assert.Equal(t, string(golden), strings.Join(feedBytes(string(raw)), ""))
+
+			whole := newStreamCleaner()
+			assert.Equal(t, string(golden), whole.Write(raw)+whole.Flush())
+		})
+	}
+}
+
+func TestStreamCleanerPreservesWhitespace(t *testing.T) {
+	chunks := feedBytes("> a  b\n\n\tindented  \n    code\n\n")
+	assert.Equal(t, "a  b\n\n\tindented  \n    code", strings.Join(chunks, ""))
+	assert.Greater(t, len(chunks), 1, "text should be emitted before the line ends")
+}
+
+func TestStreamCleanerSplitsRunesSafely(t *testing.T) {
+	chunks := feedBytes("> héllo 🤖 \x1b[1mbold\x1b[0m\n")
+	for _, chunk := range chunks {
+		assert.True(t, utf8.ValidString(chunk), "chunk %q is not valid UTF-8", chunk)
+		assert.NotContains(t, chunk, "\x1b")
+	}
+	assert.Equal(t, "héllo 🤖 bold", strings.Join(chunks, ""))
+}
+
+func TestChunkStreamerFlushesOnSize(t *testing.T) {
+	var chunks []string
+	s := newChunkStreamer(func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	s.size = 4
+	s.interval = time.Hour
+
+	for _, part := range []string{"> ab", "cd", "e", "fgh"} {
+		_, err := s.Write([]byte(part))
+		require.NoError(t, err)
+	}
+	require.NoError(t, s.Close())
+	assert.Equal(t, []string{"abcd", "efgh"}, chunks)
+}
+
+func TestChunkStreamerFlushesOnInterval(t *testing.T) {
+	flushed := make(chan string, 1)
+	s := newChunkStreamer(func(chunk string) error {
+		flushed <- chunk
+		return nil
+	})
+	s.size = 1 << 20
+	s.interval = 10 * time.Millisecond
+
+	_, err := s.Write([]byte("> partial"))
+	require.NoError(t, err)
+	select {
+	case chunk := <-flushed:
+		assert.Equal(t, "partial", chunk)
+	case <-time.After(5 * time.Second):
+		t.Fatal("buffered output was never flushed")
+	}
+	require.NoError(t, s.Close())
+}
+
+func TestStreamLongLine(t *testing.T) {
+	useFakeQ(t, "#!/bin/sh\nhead -c 100000 /dev/zero | tr '\\0' a\necho\n")
+
+	var response strings.Builder
+	err := (&QCLIBackend{}).Stream(t.Context(), QRequest{Prompt: "hi"}, func(chunk string) error {
+		response.WriteString(chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 100000), response.String())
+}
+
+func TestStreamEmitsBeforeLineEnds(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "seen")
+	t.Setenv("SEEN_MARKER", marker)
+	// q only finishes its line once the first chunk has reached the client
+	useFakeQ(t, "#!/bin/sh\nprintf 'Hello, '\nwhile [ ! -f \"$SEEN_MARKER\" ]; do sleep 0.01; done\nprintf 'world\\n'\n")
+
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	var chunks []string
+	err := (&QCLIBackend{}).Stream(ctx, QRequest{Prompt: "hi"}, func(chunk string) error {
+		if len(chunks) == 0 {
+			assert.NoError(t, os.WriteFile(marker, nil, 0644))
+		}
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hello, ", "world"}, chunks)
+}
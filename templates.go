@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// defaultTemplate places the system prompt ahead of the user prompt, like
+// Ollama's generic completion template. q has no fill-in-the-middle mode, so
+// a suffix turns the prompt into an instruction to write the missing text.
+const defaultTemplate = "{{ if .System }}{{ .System }}\n\n{{ end }}" +
+	"{{ if .Suffix }}Write the text that belongs between <prefix> and <suffix>. Reply with only that text.\n\n" +
+	"<prefix>{{ .Prompt }}</prefix>\n<suffix>{{ .Suffix }}</suffix>{{ else }}{{ .Prompt }}{{ end }}" +
+	"{{ if .Response }}\n\n{{ .Response }}{{ end }}"
+
+// templateData is the value templates are executed against
+type templateData struct {
+	System   string
+	Prompt   string
+	Suffix   string
+	Response string
+}
+
+// templateForModel returns the prompt template used for model
+func templateForModel(model string) string {
+	if tmpl := resolveModel(model).Template; tmpl != "" {
+		return tmpl
+	}
+	return defaultTemplate
+}
+
+// renderTemplate executes an Ollama-style Go template
+func renderTemplate(tmpl string, data templateData) (string, error) {
+	t, err := template.New("prompt").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %v", err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render template: %v", err)
+	}
+	return b.String(), nil
+}
+
+// generatePrompt builds the prompt for a generate request. Raw requests are
+// sent verbatim; otherwise the request or model template is applied to every
+// earlier turn of the conversation and then to the new prompt. When that
+// exceeds the history limit, the oldest turns are dropped first, as they are
+// for chat.
+func generatePrompt(req GenerateRequest, history []conversationTurn) (string, error) {
+	if req.Raw {
+		return req.Prompt, nil
+	}
+	tmpl := req.Template
+	if tmpl == "" {
+		tmpl = templateForModel(req.Model)
+	}
+	system := req.System
+	if system == "" {
+		system = resolveModel(req.Model).System
+	}
+
+	turns := make([]conversationTurn, 0, len(history)+1)
+	turns = append(append(turns, history...), conversationTurn{Prompt: req.Prompt})
+	prompt, err := renderTurns(tmpl, system, req.Suffix, turns, false)
+	for dropped := 1; err == nil && config.ChatMaxChars > 0 && len(prompt) > config.ChatMaxChars && dropped < len(turns); dropped++ {
+		prompt, err = renderTurns(tmpl, system, req.Suffix, turns[dropped:], true)
+	}
+	return prompt, err
+}
+
+// renderTurns applies tmpl to each turn. The system prompt goes with the
+// first turn, which notes that earlier turns were dropped when truncated is
+// set, and the suffix with the last.
+func renderTurns(tmpl, system, suffix string, turns []conversationTurn, truncated bool) (string, error) {
+	parts := make([]string, 0, len(turns))
+	for i, turn := range turns {
+		data := templateData{Prompt: turn.Prompt, Response: turn.Response}
+		if i == 0 {
+			data.System = system
+			if truncated {
+				data.Prompt = truncationNotice + "\n\n" + data.Prompt
+			}
+		}
+		if i == len(turns)-1 {
+			data.Suffix = suffix
+		}
+		part, err := renderTemplate(tmpl, data)
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratePromptDefaultTemplate(t *testing.T) {
+	prompt, err := generatePrompt(GenerateRequest{Model: "amazon-q", Prompt: "Hi"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Hi", prompt)
+
+	prompt, err = generatePrompt(GenerateRequest{Model: "amazon-q:latest", System: "Be terse", Prompt: "Hi"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Be terse\n\nHi", prompt)
+}
+
+func TestGeneratePromptCustomTemplate(t *testing.T) {
+	prompt, err := generatePrompt(GenerateRequest{
+		Prompt:   "list buckets",
+		System:   "AWS expert",
+		Template: "[{{ .System }}] {{ .Prompt }}",
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "[AWS expert] list buckets", prompt)
+}
+
+func TestGeneratePromptRaw(t *testing.T) {
+	prompt, err := generatePrompt(GenerateRequest{
+		Prompt:   "{{ not a template",
+		System:   "ignored",
+		Template: "ignored {{ .Prompt }}",
+		Raw:      true,
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "{{ not a template", prompt)
+}
+
+func TestGenerateInvalidTemplate(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "unused"})
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Prompt: "hi", Template: "{{ .Prompt"})
+
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid template")
+}
+
+func TestGenerateSendsSystemPrompt(t *testing.T) {
+	fake := &fakeBackend{response: "ok"}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/api/generate", GenerateRequest{Model: "amazon-q", System: "You are an AWS expert", Prompt: "Explain IAM"})
+
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "You are an AWS expert\n\nExplain IAM", fake.requests[0].Prompt)
+}
+
+func TestGeneratePromptDropsOldestTurns(t *testing.T) {
+	previous := config.ChatMaxChars
+	config.ChatMaxChars = 90
+	t.Cleanup(func() { config.ChatMaxChars = previous })
+
+	history := []conversationTurn{
+		{Prompt: "first question with plenty of detail", Response: "first answer"},
+		{Prompt: "second question", Response: "second answer"},
+	}
+	prompt, err := generatePrompt(GenerateRequest{System: "Be brief", Prompt: "third question"}, history)
+	require.NoError(t, err)
+	assert.Equal(t, "Be brief\n\n"+truncationNotice+"\n\nsecond question\n\nsecond answer\n\nthird question", prompt)
+
+	// The new prompt is always kept, even when it alone is over the limit
+	long := strings.Repeat("x", 100)
+	prompt, err = generatePrompt(GenerateRequest{Prompt: long}, history)
+	require.NoError(t, err)
+	assert.Equal(t, truncationNotice+"\n\n"+long, prompt)
+}
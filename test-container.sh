@@ -40,7 +40,15 @@ print_status() {
     esac
 }
 
-# Function to run a test
+# Function to check a status code against one or more "|"-separated statuses
+status_matches() {
+    case "|$2|" in
+        *"|$1|"*) return 0 ;;
+        *) return 1 ;;
+    esac
+}
+
+# Function to run a test; expected_status may list alternatives, e.g. "401|503"
 run_test() {
     local test_name=$1
     local curl_command=$2
@@ -54,7 +62,7 @@ run_test() {
     response=$(eval "$curl_command" 2>/dev/null)
     status_code=$(eval "$curl_command -w '%{http_code}' -o /dev/null -s" 2>/dev/null)
     
-    if [ "$status_code" = "$expected_status" ]; then
+    if status_matches "$status_code" "$expected_status"; then
         print_status "SUCCESS" "✓ $test_name (HTTP $status_code)"
         PASSED_TESTS=$((PASSED_TESTS + 1))
         return 0
@@ -80,7 +88,7 @@ run_test_with_validation() {
     response=$(eval "$curl_command" 2>/dev/null)
     status_code=$(eval "$curl_command -w '%{http_code}' -o /dev/null -s" 2>/dev/null)
     
-    if [ "$status_code" = "$expected_status" ]; then
+    if status_matches "$status_code" "$expected_status"; then
         if [ -n "$validation_pattern" ] && ! echo "$response" | grep -q "$validation_pattern"; then
             print_status "ERROR" "✗ $test_name (HTTP $status_code but response validation failed)"
             print_status "ERROR" "Expected pattern: $validation_pattern"
@@ -201,7 +209,12 @@ run_all_tests() {
         200 \
         '"modelfile"'
     
-    # Process management endpoints
+    # Process management endpoints; the model is listed once it is loaded
+    run_test_with_validation "Load Model" \
+        "curl -s -X POST '$BASE_URL/api/generate' -H 'Content-Type: application/json' -d '{\"model\": \"amazon-q\"}'" \
+        200 \
+        '"done_reason":"load"'
+
     run_test_with_validation "PS Endpoint" \
         "curl -s '$BASE_URL/api/ps'" \
         200 \
@@ -212,24 +225,32 @@ run_all_tests() {
         200 \
         '"status":"running"'
     
-    # Generation endpoints (these will fail without actual Q CLI, but should return proper error responses)
+    # Generation endpoints (these fail without a logged-in Q CLI: 401 when q
+    # is not logged in, 503 when it is not installed)
     run_test "Generate Endpoint" \
         "curl -s -X POST '$BASE_URL/api/generate' -H 'Content-Type: application/json' -d '{\"model\": \"amazon-q\", \"prompt\": \"Hello\"}'" \
-        500
+        "401|503"
     
     run_test "Chat Endpoint" \
         "curl -s -X POST '$BASE_URL/api/chat' -H 'Content-Type: application/json' -d '{\"model\": \"amazon-q\", \"messages\": [{\"role\": \"user\", \"content\": \"Hello\"}]}'" \
-        500
+        "401|503"
     
     # Chat endpoint with no user message (should return 400)
     run_test "Chat Endpoint - No User Message" \
         "curl -s -X POST '$BASE_URL/api/chat' -H 'Content-Type: application/json' -d '{\"model\": \"amazon-q\", \"messages\": [{\"role\": \"system\", \"content\": \"You are helpful\"}]}'" \
         400
     
-    # Model management endpoints (should return 501 Not Implemented)
-    run_test "Create Endpoint" \
+    # Model creation builds a virtual model from a Modelfile
+    run_test_with_validation "Create Endpoint" \
+        "curl -s -X POST '$BASE_URL/api/create' -H 'Content-Type: application/json' -d '{\"name\": \"test-model\", \"modelfile\": \"FROM amazon-q\", \"stream\": false}'" \
+        200 \
+        '"status":"success"'
+
+    run_test "Create Endpoint - No Modelfile" \
         "curl -s -X POST '$BASE_URL/api/create' -H 'Content-Type: application/json' -d '{\"name\": \"test-model\"}'" \
-        501
+        400
+
+    # Other model management endpoints (should return 501 Not Implemented)
     
     run_test "Pull Endpoint" \
         "curl -s -X POST '$BASE_URL/api/pull' -H 'Content-Type: application/json' -d '{\"name\": \"test-model\"}'" \
@@ -285,14 +306,14 @@ run_all_tests() {
         "curl -s -X POST '$BASE_URL/api/generate' -H 'Content-Type: application/json' -d 'invalid json'" \
         400
     
-    # Streaming endpoints
+    # Streaming endpoints report a failure before the first chunk the same way
     run_test "Streaming Generate" \
         "curl -s -X POST '$BASE_URL/api/generate' -H 'Content-Type: application/json' -d '{\"model\": \"amazon-q\", \"prompt\": \"Hello\", \"stream\": true}'" \
-        200
+        "401|503"
     
     run_test "Streaming Chat" \
         "curl -s -X POST '$BASE_URL/api/chat' -H 'Content-Type: application/json' -d '{\"model\": \"amazon-q\", \"messages\": [{\"role\": \"user\", \"content\": \"Hello\"}], \"stream\": true}'" \
-        200
+        "401|503"
 }
 
 # Function to run performance tests
@@ -50,12 +50,21 @@ print_status() {
     esac
 }
 
+# Function to check a status code against one or more "|"-separated statuses
+status_matches() {
+    case "|$2|" in
+        *"|$1|"*) return 0 ;;
+        *) return 1 ;;
+    esac
+}
+
 # Function to run a test
 run_test() {
     local test_name=$1
     local method=${2:-"GET"}
     local endpoint=$3
     local data=$4
+    # expected_status may list alternatives, e.g. "401|503"
     local expected_status=${5:-200}
     local validation_pattern=$6
     
@@ -86,7 +95,7 @@ run_test() {
     fi
     
     # Check status code
-    if [ "$status_code" = "$expected_status" ]; then
+    if status_matches "$status_code" "$expected_status"; then
         # Check response pattern if provided
         if [ -n "$validation_pattern" ] && [ -n "$response" ]; then
             if echo "$response" | grep -q "$validation_pattern"; then
@@ -151,25 +160,30 @@ run_all_tests() {
     # Model information endpoints
     run_test "Tags Endpoint" "GET" "/api/tags" "" 200 '"amazon-q:latest"'
     run_test "List Endpoint" "GET" "/api/list" "" 200 '"amazon-q:latest"'
+    run_test "Load Model" "POST" "/api/generate" '{"model": "amazon-q"}' 200 '"done_reason":"load"'
     run_test "PS Endpoint" "GET" "/api/ps" "" 200 '"amazon-q:latest"'
     run_test "Status Endpoint" "GET" "/api/status" "" 200 '"status":"running"'
     
     # Show endpoint
     run_test "Show Endpoint" "POST" "/api/show" '{"name": "amazon-q"}' 200 '"modelfile"'
     
-    # Generation endpoints (will fail without Q CLI but should handle gracefully)
-    run_test "Generate Endpoint" "POST" "/api/generate" '{"model": "amazon-q", "prompt": "Hello"}' 500
-    run_test "Chat Endpoint" "POST" "/api/chat" '{"model": "amazon-q", "messages": [{"role": "user", "content": "Hello"}]}' 500
+    # Generation endpoints (will fail without a logged-in Q CLI: 401 when
+    # q is not logged in, 503 when it is not installed)
+    run_test "Generate Endpoint" "POST" "/api/generate" '{"model": "amazon-q", "prompt": "Hello", "stream": false}' "401|503" '"code"'
+    run_test "Chat Endpoint" "POST" "/api/chat" '{"model": "amazon-q", "messages": [{"role": "user", "content": "Hello"}], "stream": false}' "401|503" '"code"'
     
     # Chat endpoint validation
     run_test "Chat No User Message" "POST" "/api/chat" '{"model": "amazon-q", "messages": [{"role": "system", "content": "You are helpful"}]}' 400
     
-    # Streaming endpoints
-    run_test "Streaming Generate" "POST" "/api/generate" '{"model": "amazon-q", "prompt": "Hello", "stream": true}' 200
-    run_test "Streaming Chat" "POST" "/api/chat" '{"model": "amazon-q", "messages": [{"role": "user", "content": "Hello"}], "stream": true}' 200
+    # Streaming endpoints report a failure before the first chunk the same way
+    run_test "Streaming Generate" "POST" "/api/generate" '{"model": "amazon-q", "prompt": "Hello", "stream": true}' "401|503"
+    run_test "Streaming Chat" "POST" "/api/chat" '{"model": "amazon-q", "messages": [{"role": "user", "content": "Hello"}], "stream": true}' "401|503"
     
-    # Model management endpoints (should return 501)
-    run_test "Create Endpoint" "POST" "/api/create" '{"name": "test-model"}' 501
+    # Model creation builds a virtual model from a Modelfile
+    run_test "Create Endpoint" "POST" "/api/create" '{"name": "test-model", "modelfile": "FROM amazon-q\nSYSTEM You are a test model", "stream": false}' 200 '"status":"success"'
+    run_test "Create Without Modelfile" "POST" "/api/create" '{"name": "test-model"}' 400
+
+    # Other model management endpoints (should return 501)
     run_test "Pull Endpoint" "POST" "/api/pull" '{"name": "test-model"}' 501
     run_test "Push Endpoint" "POST" "/api/push" '{"name": "test-model"}' 501
     run_test "Delete Endpoint" "DELETE" "/api/delete" '{"name": "test-model"}' 501
@@ -185,7 +199,7 @@ run_all_tests() {
     
     # Error handling tests
     run_test "Invalid JSON" "POST" "/api/generate" 'invalid json' 400
-    run_test "Missing Content-Type" "POST" "/api/generate" '{"model": "amazon-q"}' 400
+    run_test "Missing Model" "POST" "/api/generate" '{}' 400
     
     # CORS test
     print_status "INFO" "Testing CORS preflight..."
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// toolCallsPrompt introduces the tool schemas injected into a chat prompt. q
+// has no native tool support, so it is asked to reply with a JSON object
+// that parseToolCalls turns back into tool_calls.
+const toolCallsPrompt = `You can call the tools listed below. To call one or more of them, reply with only a JSON object of this form and no other text:
+{"tool_calls": [{"name": "<tool name>", "arguments": {<arguments matching the tool's parameters>}}]}
+If no tool is needed, answer normally. Tool results are returned to you in later messages.
+
+Tools:`
+
+// toolInstructions renders the system message that offers tools to q
+func toolInstructions(tools []Tool) string {
+	lines := []string{toolCallsPrompt}
+	for _, tool := range tools {
+		schema, _ := json.Marshal(map[string]interface{}{
+			"name":        tool.Function.Name,
+			"description": tool.Function.Description,
+			"parameters":  tool.Function.Parameters,
+		})
+		lines = append(lines, string(schema))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withToolInstructions puts the tool instructions ahead of the conversation
+func withToolInstructions(messages []Message, tools []Tool) []Message {
+	if len(tools) == 0 {
+		return messages
+	}
+	return append([]Message{{Role: "system", Content: toolInstructions(tools)}}, messages...)
+}
+
+// toolCallsJSON renders tool calls the way q is asked to write them, so
+// earlier calls in the transcript read as examples of the format
+func toolCallsJSON(calls []ToolCall) string {
+	type call struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	rendered := make([]call, 0, len(calls))
+	for _, c := range calls {
+		rendered = append(rendered, call{Name: c.Function.Name, Arguments: c.Function.Arguments})
+	}
+	data, _ := json.Marshal(map[string]interface{}{"tool_calls": rendered})
+	return string(data)
+}
+
+// emittedToolCall is one tool call as q writes it. The OpenAI-style
+// {"function": {...}} wrapping and string-encoded arguments are accepted too.
+type emittedToolCall struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+	Function  *struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// parseToolCalls finds the first {"tool_calls": [...]} object in output that
+// names only the offered tools. It returns the calls and the text around
+// the object, or no calls when output is an ordinary answer.
+func parseToolCalls(output string, tools []Tool) ([]ToolCall, string) {
+	if len(tools) == 0 {
+		return nil, output
+	}
+	offered := make(map[string]bool, len(tools))
+	for _, tool := range tools {
+		offered[tool.Function.Name] = true
+	}
+
+	for start := strings.IndexByte(output, '{'); start >= 0; {
+		decoder := json.NewDecoder(strings.NewReader(output[start:]))
+		var emitted struct {
+			ToolCalls []emittedToolCall `json:"tool_calls"`
+		}
+		if err := decoder.Decode(&emitted); err == nil && len(emitted.ToolCalls) > 0 {
+			if calls, err := toToolCalls(emitted.ToolCalls, offered); err == nil {
+				end := start + int(decoder.InputOffset())
+				return calls, stripCodeFence(output[:start] + output[end:])
+			}
+		}
+		next := strings.IndexByte(output[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return nil, output
+}
+
+func toToolCalls(emitted []emittedToolCall, offered map[string]bool) ([]ToolCall, error) {
+	calls := make([]ToolCall, 0, len(emitted))
+	for _, e := range emitted {
+		name, raw := e.Name, e.Arguments
+		if e.Function != nil {
+			name, raw = e.Function.Name, e.Function.Arguments
+		}
+		if !offered[name] {
+			return nil, fmt.Errorf("unknown tool %q", name)
+		}
+		arguments, err := toolArguments(raw)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, ToolCall{Function: FunctionCall{Name: name, Arguments: arguments}})
+	}
+	return calls, nil
+}
+
+// toolArguments decodes arguments given as a JSON object or as a string
+// holding one
+func toolArguments(raw json.RawMessage) (map[string]interface{}, error) {
+	arguments := map[string]interface{}{}
+	if len(raw) == 0 || string(raw) == "null" {
+		return arguments, nil
+	}
+	var encoded string
+	if err := json.Unmarshal(raw, &encoded); err == nil {
+		raw = json.RawMessage(encoded)
+	}
+	if err := json.Unmarshal(raw, &arguments); err != nil {
+		return nil, fmt.Errorf("tool arguments must be a JSON object: %v", err)
+	}
+	return arguments, nil
+}
+
+// stripCodeFence trims whitespace and a markdown fence left around a tool
+// call once the call itself has been removed
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	for _, fence := range []string{"```json", "```"} {
+		if strings.HasPrefix(text, fence) {
+			text = strings.TrimSpace(text[len(fence):])
+			break
+		}
+	}
+	return strings.TrimSpace(strings.TrimSuffix(text, "```"))
+}
+
+// assistantMessage builds the reply to a chat request, turning a tool call
+// in q's output into tool_calls
+func assistantMessage(response string, tools []Tool) Message {
+	calls, content := parseToolCalls(response, tools)
+	return Message{Role: "assistant", Content: content, ToolCalls: calls}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var weatherTools = []Tool{{
+	Type: "function",
+	Function: Function{
+		Name:        "get_weather",
+		Description: "Current weather for a city",
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}},
+			"required":   []interface{}{"city"},
+		},
+	},
+}}
+
+func TestParseToolCalls(t *testing.T) {
+	paris := []ToolCall{{Function: FunctionCall{Name: "get_weather", Arguments: map[string]interface{}{"city": "Paris"}}}}
+
+	cases := []struct {
+		name    string
+		output  string
+		calls   []ToolCall
+		content string
+	}{
+		{"bare object", `{"tool_calls": [{"name": "get_weather", "arguments": {"city": "Paris"}}]}`, paris, ""},
+		{"code fence", "```json\n{\"tool_calls\": [{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}]}\n```", paris, ""},
+		{"surrounding text", "Let me check.\n{\"tool_calls\": [{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}]}", paris, "Let me check."},
+		{"function wrapper", `{"tool_calls": [{"function": {"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}}]}`, paris, ""},
+		{"plain answer", "It is sunny in Paris.", nil, "It is sunny in Paris."},
+		{"unknown tool", `{"tool_calls": [{"name": "rm_rf", "arguments": {}}]}`, nil, `{"tool_calls": [{"name": "rm_rf", "arguments": {}}]}`},
+		{"other json", "Use {\"city\": \"Paris\"} as input", nil, "Use {\"city\": \"Paris\"} as input"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls, content := parseToolCalls(tc.output, weatherTools)
+			assert.Equal(t, tc.calls, calls)
+			assert.Equal(t, tc.content, content)
+		})
+	}
+}
+
+func TestParseToolCallsWithoutTools(t *testing.T) {
+	output := `{"tool_calls": [{"name": "get_weather", "arguments": {"city": "Paris"}}]}`
+	calls, content := parseToolCalls(output, nil)
+	assert.Nil(t, calls)
+	assert.Equal(t, output, content)
+}
+
+func TestChatPromptOffersTools(t *testing.T) {
+	qreq := chatRequest(ChatRequest{
+		Messages: []Message{
+			{Role: "user", Content: "Weather in Paris?"},
+			{Role: "assistant", ToolCalls: []ToolCall{{Function: FunctionCall{Name: "get_weather", Arguments: map[string]interface{}{"city": "Paris"}}}}},
+			{Role: "tool", Content: "18C and sunny", ToolName: "get_weather"},
+		},
+		Tools: weatherTools,
+	})
+
+	assert.True(t, strings.HasPrefix(qreq.Prompt, "System: "+toolCallsPrompt))
+	assert.Contains(t, qreq.Prompt, `{"description":"Current weather for a city","name":"get_weather","parameters":{`)
+	assert.Contains(t, qreq.Prompt, `Assistant: {"tool_calls":[{"name":"get_weather","arguments":{"city":"Paris"}}]}`)
+	assert.Contains(t, qreq.Prompt, "Tool result (get_weather): 18C and sunny")
+}
+
+func TestChatReturnsToolCalls(t *testing.T) {
+	useBackend(t, &fakeBackend{response: `{"tool_calls": [{"name": "get_weather", "arguments": {"city": "Paris"}}]}`})
+
+	w := postJSON(t, "/api/chat", ChatRequest{
+		Model:    "amazon-q",
+		Messages: []Message{{Role: "user", Content: "Weather in Paris?"}},
+		Tools:    weatherTools,
+		Stream:   boolPtr(false),
+	})
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	message := response["message"].(map[string]interface{})
+	assert.Equal(t, "", message["content"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"function": map[string]interface{}{"name": "get_weather", "arguments": map[string]interface{}{"city": "Paris"}},
+	}}, message["tool_calls"])
+	assert.Equal(t, "stop", response["done_reason"])
+}
+
+func TestStreamingChatReturnsToolCallsInOneChunk(t *testing.T) {
+	useBackend(t, &fakeBackend{response: `{"tool_calls": [{"name": "get_weather", "arguments": {"city": "Paris"}}]}`})
+
+	w := postJSON(t, "/api/chat", ChatRequest{
+		Model:    "amazon-q",
+		Messages: []Message{{Role: "user", Content: "Weather in Paris?"}},
+		Tools:    weatherTools,
+	})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	frames := readNDJSON(t, w.Body.String())
+	require.Len(t, frames, 2)
+	calls := frames[0]["message"].(map[string]interface{})["tool_calls"].([]interface{})
+	require.Len(t, calls, 1)
+	assert.Equal(t, "get_weather", calls[0].(map[string]interface{})["function"].(map[string]interface{})["name"])
+	assert.Equal(t, true, frames[1]["done"])
+}
+
+func TestOpenAIToolCalls(t *testing.T) {
+	fake := &fakeBackend{response: `{"tool_calls": [{"name": "get_weather", "arguments": {"city": "Paris"}}]}`}
+	useBackend(t, fake)
+
+	body := map[string]interface{}{
+		"tools": weatherTools,
+		"messages": []map[string]interface{}{
+			{"role": "user", "content": "Weather in Paris and Rome?"},
+			{"role": "assistant", "content": nil, "tool_calls": []map[string]interface{}{{
+				"id": "call_1", "type": "function",
+				"function": map[string]string{"name": "get_weather", "arguments": `{"city":"Rome"}`},
+			}}},
+			{"role": "tool", "tool_call_id": "call_1", "content": "25C"},
+		},
+	}
+	w := postJSON(t, "/v1/chat/completions", body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var completion OpenAIChatCompletion
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &completion))
+	assert.Equal(t, "tool_calls", *completion.Choices[0].FinishReason)
+	calls := completion.Choices[0].Message.ToolCalls
+	require.Len(t, calls, 1)
+	assert.True(t, strings.HasPrefix(calls[0].ID, "call_"))
+	assert.Equal(t, "function", calls[0].Type)
+	assert.Equal(t, "get_weather", calls[0].Function.Name)
+	assert.JSONEq(t, `{"city":"Paris"}`, calls[0].Function.Arguments)
+	assert.Contains(t, fake.requests[0].Prompt, "Tool result (get_weather): 25C")
+
+	body["stream"] = true
+	w = postJSON(t, "/v1/chat/completions", body)
+	events, done := readSSE(t, w.Body.String())
+	assert.True(t, done)
+	require.Len(t, events, 3)
+	delta := events[1]["choices"].([]interface{})[0].(map[string]interface{})["delta"].(map[string]interface{})
+	call := delta["tool_calls"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, float64(0), call["index"])
+	assert.Equal(t, "tool_calls", events[2]["choices"].([]interface{})[0].(map[string]interface{})["finish_reason"])
+}
+
+func TestAnthropicToolUseResponse(t *testing.T) {
+	useBackend(t, &fakeBackend{response: "Checking.\n{\"tool_calls\": [{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}]}"})
+
+	body := map[string]interface{}{
+		"max_tokens": 100,
+		"tools":      []map[string]interface{}{{"name": "get_weather", "input_schema": map[string]string{"type": "object"}}},
+		"messages":   []map[string]string{{"role": "user", "content": "Weather in Paris?"}},
+	}
+	w := postJSON(t, "/v1/messages", body)
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var message AnthropicResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &message))
+	assert.Equal(t, "tool_use", *message.StopReason)
+	require.Len(t, message.Content, 2)
+	assert.Equal(t, AnthropicBlock{Type: "text", Text: "Checking."}, message.Content[0])
+	assert.Equal(t, "tool_use", message.Content[1].Type)
+	assert.True(t, strings.HasPrefix(message.Content[1].ID, "toolu_"))
+	assert.JSONEq(t, `{"city":"Paris"}`, string(message.Content[1].Input))
+
+	body["stream"] = true
+	w = postJSON(t, "/v1/messages", body)
+	events := readSSEEvents(t, w.Body.String())
+	require.Len(t, events, 9)
+	assert.Equal(t, "tool_use", events[4].data["content_block"].(map[string]interface{})["type"])
+	assert.Equal(t, "input_json_delta", events[5].data["delta"].(map[string]interface{})["type"])
+	assert.Equal(t, "tool_use", events[7].data["delta"].(map[string]interface{})["stop_reason"])
+}
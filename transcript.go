@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transcript formats understood by Q_CHAT_FORMAT
+const (
+	transcriptPlain    = "plain"
+	transcriptXML      = "xml"
+	transcriptMarkdown = "markdown"
+)
+
+// truncationNotice replaces turns dropped to fit the history limit
+const truncationNotice = "[Earlier conversation truncated]"
+
+// chatPrompt renders a conversation into the prompt sent to q, along with the
+// images attached to the latest user message
+func chatPrompt(messages []Message) (string, []string) {
+	_, images := lastUserMessage(messages)
+	return renderTranscript(messages, config.ChatFormat, config.ChatMaxChars), images
+}
+
+// chatRequest builds the q request for a chat call, streamed or not, with
+// the defaults of the requested model applied
+func chatRequest(req ChatRequest) QRequest {
+	model := resolveModel(req.Model)
+	messages := withModel(req.Messages, model)
+	prompt, images := chatPrompt(withToolInstructions(messages, req.Tools))
+	return QRequest{Prompt: prompt, Images: images, Options: mergeOptions(model.Options, req.Options), Flags: model.QFlags}
+}
+
+// renderTranscript flattens messages into a single prompt. A lone user
+// message is passed through unchanged. When the transcript exceeds maxChars,
+// the oldest non-system turns are dropped first; system messages and the
+// final message are always kept.
+func renderTranscript(messages []Message, format string, maxChars int) string {
+	if len(messages) == 1 && messages[0].Role == "user" {
+		return messages[0].Content
+	}
+
+	keep := make([]bool, len(messages))
+	for i := range messages {
+		keep[i] = true
+	}
+
+	rendered := joinTurns(messages, keep, format, false)
+	for i := 0; maxChars > 0 && len(rendered) > maxChars && i < len(messages)-1; i++ {
+		if messages[i].Role == "system" {
+			continue
+		}
+		keep[i] = false
+		rendered = joinTurns(messages, keep, format, true)
+	}
+	return rendered
+}
+
+func joinTurns(messages []Message, keep []bool, format string, truncated bool) string {
+	var turns []string
+	noticeAdded := false
+	for i, msg := range messages {
+		if !keep[i] {
+			if truncated && !noticeAdded {
+				turns = append(turns, truncationNotice)
+				noticeAdded = true
+			}
+			continue
+		}
+		turns = append(turns, renderTurn(msg, format))
+	}
+	return strings.Join(turns, "\n\n")
+}
+
+func renderTurn(msg Message, format string) string {
+	content := turnContent(msg)
+	switch format {
+	case transcriptXML:
+		return fmt.Sprintf("<%s>\n%s\n</%s>", msg.Role, content, msg.Role)
+	case transcriptMarkdown:
+		return fmt.Sprintf("### %s\n%s", turnLabel(msg), content)
+	default:
+		return fmt.Sprintf("%s: %s", turnLabel(msg), content)
+	}
+}
+
+// turnContent is a message's text followed by any tool calls it made
+func turnContent(msg Message) string {
+	if len(msg.ToolCalls) == 0 {
+		return msg.Content
+	}
+	if msg.Content == "" {
+		return toolCallsJSON(msg.ToolCalls)
+	}
+	return msg.Content + "\n" + toolCallsJSON(msg.ToolCalls)
+}
+
+// turnLabel names the speaker of a turn, including which tool a result is from
+func turnLabel(msg Message) string {
+	if msg.Role == "tool" && msg.ToolName != "" {
+		return fmt.Sprintf("%s (%s)", roleLabel(msg.Role), msg.ToolName)
+	}
+	return roleLabel(msg.Role)
+}
+
+func roleLabel(role string) string {
+	switch role {
+	case "system":
+		return "System"
+	case "user":
+		return "User"
+	case "assistant":
+		return "Assistant"
+	case "tool":
+		return "Tool result"
+	default:
+		if role == "" {
+			return "User"
+		}
+		return strings.ToUpper(role[:1]) + role[1:]
+	}
+}
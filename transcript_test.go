@@ -0,0 +1,76 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var conversation = []Message{
+	{Role: "system", Content: "You are a Go expert"},
+	{Role: "user", Content: "What is a goroutine?"},
+	{Role: "assistant", Content: "A lightweight thread."},
+	{Role: "tool", Content: `{"docs": "go.dev"}`},
+	{Role: "user", Content: "Show an example"},
+}
+
+func TestRenderTranscriptSingleUserMessage(t *testing.T) {
+	prompt := renderTranscript([]Message{{Role: "user", Content: "Hello"}}, transcriptPlain, 0)
+	assert.Equal(t, "Hello", prompt)
+}
+
+func TestRenderTranscriptPlain(t *testing.T) {
+	prompt := renderTranscript(conversation, transcriptPlain, 0)
+	assert.Equal(t, "System: You are a Go expert\n\n"+
+		"User: What is a goroutine?\n\n"+
+		"Assistant: A lightweight thread.\n\n"+
+		"Tool result: {\"docs\": \"go.dev\"}\n\n"+
+		"User: Show an example", prompt)
+}
+
+func TestRenderTranscriptXML(t *testing.T) {
+	prompt := renderTranscript(conversation[:2], transcriptXML, 0)
+	assert.Equal(t, "<system>\nYou are a Go expert\n</system>\n\n<user>\nWhat is a goroutine?\n</user>", prompt)
+}
+
+func TestRenderTranscriptMarkdown(t *testing.T) {
+	prompt := renderTranscript(conversation[1:3], transcriptMarkdown, 0)
+	assert.Equal(t, "### User\nWhat is a goroutine?\n\n### Assistant\nA lightweight thread.", prompt)
+}
+
+func TestRenderTranscriptTruncatesOldestTurns(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "Be brief"},
+		{Role: "user", Content: strings.Repeat("old ", 50)},
+		{Role: "assistant", Content: strings.Repeat("reply ", 50)},
+		{Role: "user", Content: "latest question"},
+	}
+
+	prompt := renderTranscript(messages, transcriptPlain, 100)
+
+	assert.Equal(t, "System: Be brief\n\n"+truncationNotice+"\n\nUser: latest question", prompt)
+}
+
+func TestRenderTranscriptKeepsLastMessageWhenOverLimit(t *testing.T) {
+	messages := []Message{
+		{Role: "user", Content: "first"},
+		{Role: "user", Content: strings.Repeat("x", 200)},
+	}
+
+	prompt := renderTranscript(messages, transcriptPlain, 50)
+
+	assert.True(t, strings.HasSuffix(prompt, strings.Repeat("x", 200)))
+	assert.NotContains(t, prompt, "first")
+}
+
+func TestStreamingChatSendsHistory(t *testing.T) {
+	fake := &fakeBackend{chunks: []string{"ok"}}
+	useBackend(t, fake)
+
+	w := postJSON(t, "/api/chat", ChatRequest{Model: "amazon-q", Messages: conversation, Stream: boolPtr(true)})
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, fake.requests[0].Prompt, "System: You are a Go expert")
+	assert.Contains(t, fake.requests[0].Prompt, "Assistant: A lightweight thread.")
+}